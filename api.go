@@ -0,0 +1,450 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ProxeusApp/node-balance-retriever/service"
+	"github.com/labstack/echo"
+	"github.com/labstack/echo/middleware"
+)
+
+type (
+	apiBalancesRequest struct {
+		Address  string   `json:"address"`
+		Block    string   `json:"block,omitempty"`
+		Date     string   `json:"date,omitempty"`
+		Tokens   []string `json:"tokens,omitempty"`
+		Networks []string `json:"networks,omitempty"`
+	}
+
+	apiJobRequest struct {
+		apiBalancesRequest
+		// Optional, receives the job once it's done
+		WebhookURL string `json:"webhookUrl,omitempty"`
+	}
+
+	apiBalancesResponse struct {
+		Address string             `json:"address"`
+		Chains  []apiChainBalances `json:"chains"`
+	}
+
+	apiChainBalances struct {
+		Network string `json:"network"`
+		// Omitted if the balance provider doesn't know at which block the balances were calculated
+		Block    *apiBlock    `json:"block,omitempty"`
+		Balances []apiBalance `json:"balances"`
+		Warnings []string     `json:"warnings,omitempty"`
+	}
+
+	apiBlock struct {
+		Number    string `json:"number"`
+		Hash      string `json:"hash"`
+		Confirmed bool   `json:"confirmed"`
+	}
+
+	apiBalance struct {
+		Symbol    string `json:"symbol"`
+		Value     string `json:"value"`
+		BaseUnits string `json:"baseUnits"`
+		Decimals  uint8  `json:"decimals"`
+		// Omitted if the price oracle doesn't know the price of the token at the date of the balances
+		Price *apiPrice `json:"price,omitempty"`
+	}
+
+	apiPrice struct {
+		Currency  string    `json:"currency"`
+		Rate      string    `json:"rate"`
+		Timestamp time.Time `json:"timestamp"`
+		// Value of the balance in the currency
+		Value string `json:"value"`
+	}
+
+	apiError struct {
+		Error string `json:"error"`
+	}
+)
+
+// Registers the REST API, authenticated with one of apiKeys sent as "Authorization: Bearer <key>". The OpenAPI document
+// is public.
+func registerAPI(e *echo.Echo, apiKeys []string) {
+	e.GET("/api/v1/openapi.json", getOpenAPIDocument)
+
+	g := e.Group("/api/v1")
+	// KeyAuth considers requests without any key bad requests, they're unauthorized like the ones with a wrong key
+	g.Use(func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			authorization := c.Request().Header.Get(echo.HeaderAuthorization)
+			if !strings.HasPrefix(authorization, "Bearer ") || len(authorization) == len("Bearer ") {
+				return echo.ErrUnauthorized
+			}
+			return next(c)
+		}
+	})
+	g.Use(middleware.KeyAuth(func(key string, c echo.Context) (bool, error) {
+		for _, apiKey := range apiKeys {
+			if subtle.ConstantTimeCompare([]byte(key), []byte(apiKey)) == 1 {
+				return true, nil
+			}
+		}
+		return false, nil
+	}))
+	g.GET("/balances/:address", getAPIBalances)
+	g.POST("/jobs", postAPIJob)
+	g.GET("/jobs/:id", getAPIJob)
+}
+
+// Returns the balances of an address, at the latest block by default. The query parameters "block" (only with a single
+// network) or "date" (ISO-8601 with timezone) set the block, "tokens" and "networks" (comma separated) restrict the
+// balances to some tokens and networks.
+func getAPIBalances(c echo.Context) error {
+	request := apiBalancesRequest{
+		Address:  c.Param("address"),
+		Block:    c.QueryParam("block"),
+		Date:     c.QueryParam("date"),
+		Tokens:   splitList(c.QueryParam("tokens")),
+		Networks: splitList(c.QueryParam("networks")),
+	}
+	err := request.validate()
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, apiError{Error: err.Error()})
+	}
+
+	response, err := request.run(c.Request().Context())
+	if err != nil {
+		return c.JSON(balancesErrorStatus(err), apiError{Error: err.Error()})
+	}
+	return c.JSON(http.StatusOK, response)
+}
+
+// Starts a job retrieving balances in the background, for requests that take too long to wait for (e.g. scanning all
+// the "Transfer" logs). The job is polled with getAPIJob, or posted to webhookUrl once done.
+func postAPIJob(c echo.Context) error {
+	var request apiJobRequest
+	decoder := json.NewDecoder(c.Request().Body)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&request)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, apiError{Error: fmt.Sprintf("invalid job: %v", err)})
+	}
+	err = request.validate()
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, apiError{Error: err.Error()})
+	}
+
+	balancesRequest, err := json.Marshal(request.apiBalancesRequest)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, apiError{Error: err.Error()})
+	}
+	job, err := jobRunner.Submit(balancesRequest, request.WebhookURL)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, apiError{Error: err.Error()})
+	}
+	return c.JSON(http.StatusAccepted, job)
+}
+
+func getAPIJob(c echo.Context) error {
+	job, err := jobRunner.Get(c.Param("id"))
+	if err == service.ErrJobNotFound {
+		return c.JSON(http.StatusNotFound, apiError{Error: err.Error()})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, apiError{Error: err.Error()})
+	}
+	return c.JSON(http.StatusOK, job)
+}
+
+// Runs the request of a job, see service.JobFunc
+func runAPIJob(ctx context.Context, requestJSON json.RawMessage) (interface{}, error) {
+	var request apiBalancesRequest
+	err := json.Unmarshal(requestJSON, &request)
+	if err != nil {
+		return nil, err
+	}
+	err = request.validate()
+	if err != nil {
+		return nil, err
+	}
+	return request.run(ctx)
+}
+
+func (me *apiBalancesRequest) validate() error {
+	addresses, err := service.UniqueAddresses([]string{me.Address})
+	if err != nil {
+		return err
+	}
+	me.Address = addresses[0]
+
+	_, err = ethereumBalanceService.Select(me.Networks)
+	if err != nil {
+		return err
+	}
+
+	if len(me.Block) != 0 && len(me.Date) != 0 {
+		return errors.New("block and date can't be combined")
+	}
+	if len(me.Block) != 0 {
+		// Block numbers are specific to a chain
+		if len(me.Networks) != 1 && len(networkNames) != 1 {
+			return errors.New("block requires a single network")
+		}
+		blockNumber, ok := new(big.Int).SetString(me.Block, 10)
+		if !ok || blockNumber.Sign() < 0 {
+			return fmt.Errorf("invalid block %q", me.Block)
+		}
+	}
+	if len(me.Date) != 0 {
+		_, err = time.Parse(time.RFC3339, me.Date)
+		if err != nil {
+			return fmt.Errorf("invalid date: %v", err)
+		}
+	}
+	return nil
+}
+
+// Retrieves the balances of a validated request
+func (me *apiBalancesRequest) run(ctx context.Context) (*apiBalancesResponse, error) {
+	balanceService, err := ethereumBalanceService.Select(me.Networks)
+	if err != nil {
+		return nil, err
+	}
+
+	var chainsBalances []service.ChainBalances
+	switch {
+	case len(me.Block) != 0:
+		blockNumber, _ := new(big.Int).SetString(me.Block, 10)
+		chainsBalances, err = balanceService.GetBalancesAtBlock(ctx, me.Address, blockNumber)
+	case len(me.Date) != 0:
+		date, _ := time.Parse(time.RFC3339, me.Date)
+		chainsBalances, err = balanceService.GetBalancesAtDate(ctx, me.Address, date)
+	default:
+		chainsBalances, err = balanceService.GetBalances(ctx, me.Address)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := make(map[string]bool)
+	for _, token := range me.Tokens {
+		tokens[token] = true
+	}
+
+	response := &apiBalancesResponse{Address: me.Address, Chains: []apiChainBalances{}}
+	for _, chainBalances := range chainsBalances {
+		chain := apiChainBalances{Network: chainBalances.Network, Balances: []apiBalance{}, Warnings: chainBalances.Warnings}
+		if chainBalances.Block != nil {
+			chain.Block = &apiBlock{
+				Number:    chainBalances.Block.Number.String(),
+				Hash:      chainBalances.Block.Hash.Hex(),
+				Confirmed: chainBalances.Block.Confirmed,
+			}
+		}
+		for symbol, amount := range chainBalances.Balances {
+			if len(tokens) != 0 && !tokens[symbol] {
+				continue
+			}
+			balance := apiBalance{
+				Symbol:    symbol,
+				Value:     amount.Format(balanceScale, balanceRoundingMode),
+				BaseUnits: amount.Value.String(),
+				Decimals:  amount.Decimals,
+			}
+			if amount.Price != nil {
+				balance.Price = &apiPrice{
+					Currency:  amount.Price.Currency,
+					Rate:      amount.Price.FormatRate(),
+					Timestamp: amount.Price.Timestamp,
+					Value:     amount.Price.Value(amount).Format(fiatScale, balanceRoundingMode),
+				}
+			}
+			chain.Balances = append(chain.Balances, balance)
+		}
+		sort.Slice(chain.Balances, func(i, j int) bool {
+			return chain.Balances[i].Symbol < chain.Balances[j].Symbol
+		})
+		response.Chains = append(response.Chains, chain)
+	}
+
+	return response, nil
+}
+
+func getOpenAPIDocument(c echo.Context) error {
+	return c.Blob(http.StatusOK, echo.MIMEApplicationJSONCharsetUTF8, []byte(openAPIDocument))
+}
+
+const openAPIDocument = `{
+  "openapi": "3.0.3",
+  "info": {
+    "title": "Node Balance Retriever",
+    "description": "Token balances of Ethereum addresses",
+    "version": "1.0.0"
+  },
+  "servers": [{"url": "/api/v1"}],
+  "security": [{"apiKey": []}],
+  "paths": {
+    "/balances/{address}": {
+      "get": {
+        "summary": "Balances of an address",
+        "operationId": "getBalances",
+        "parameters": [
+          {"name": "address", "in": "path", "required": true, "description": "Ethereum address", "schema": {"type": "string", "pattern": "^0x[0-9a-fA-F]{40}$"}},
+          {"name": "block", "in": "query", "description": "Block number to calculate the balances at, latest block by default. Requires a single network", "schema": {"type": "string", "pattern": "^[0-9]+$"}},
+          {"name": "date", "in": "query", "description": "ISO-8601 date with timezone, the balances are calculated at the last block mined at or before it. Can't be combined with block", "schema": {"type": "string", "format": "date-time"}},
+          {"name": "tokens", "in": "query", "description": "Comma separated symbols of the tokens to return, all the tokens by default", "schema": {"type": "string"}},
+          {"name": "networks", "in": "query", "description": "Comma separated networks, all the configured networks by default", "schema": {"type": "string"}}
+        ],
+        "responses": {
+          "200": {
+            "description": "Balances on every network",
+            "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Balances"}}}
+          },
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"description": "Invalid API key"},
+          "500": {"$ref": "#/components/responses/Error"},
+          "502": {"$ref": "#/components/responses/Error"},
+          "503": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/jobs": {
+      "post": {
+        "summary": "Start retrieving the balances of an address in the background",
+        "description": "For requests taking longer than an HTTP client wants to wait, e.g. when the Transfer logs of the whole chain are scanned. The job is polled with GET /jobs/{id}, or posted to webhookUrl once done. Jobs survive a restart of the service.",
+        "operationId": "createJob",
+        "requestBody": {
+          "required": true,
+          "content": {"application/json": {"schema": {"$ref": "#/components/schemas/JobRequest"}}}
+        },
+        "responses": {
+          "202": {
+            "description": "Job started",
+            "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Job"}}}
+          },
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"description": "Invalid API key"}
+        }
+      }
+    },
+    "/jobs/{id}": {
+      "get": {
+        "summary": "Status, progress and result of a job",
+        "operationId": "getJob",
+        "parameters": [
+          {"name": "id", "in": "path", "required": true, "schema": {"type": "string"}}
+        ],
+        "responses": {
+          "200": {
+            "description": "Job",
+            "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Job"}}}
+          },
+          "401": {"description": "Invalid API key"},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    }
+  },
+  "components": {
+    "securitySchemes": {
+      "apiKey": {"type": "http", "scheme": "bearer", "description": "One of the keys of PROXEUS_API_KEYS"}
+    },
+    "responses": {
+      "Error": {
+        "description": "Invalid request or balance provider error",
+        "content": {"application/json": {"schema": {"type": "object", "properties": {"error": {"type": "string"}}}}}
+      }
+    },
+    "schemas": {
+      "Balances": {
+        "type": "object",
+        "properties": {
+          "address": {"type": "string", "description": "EIP-55 checksummed address"},
+          "chains": {"type": "array", "items": {"$ref": "#/components/schemas/ChainBalances"}}
+        }
+      },
+      "ChainBalances": {
+        "type": "object",
+        "properties": {
+          "network": {"type": "string", "example": "mainnet"},
+          "block": {"$ref": "#/components/schemas/Block"},
+          "balances": {"type": "array", "items": {"$ref": "#/components/schemas/Balance"}},
+          "warnings": {"type": "array", "items": {"type": "string"}, "description": "Problems that didn't prevent retrieving the balances, e.g. tokens ignored because they use the symbol of a configured token with another contract"}
+        }
+      },
+      "Block": {
+        "type": "object",
+        "description": "Block the balances were calculated at, omitted if the balance provider doesn't know it",
+        "properties": {
+          "number": {"type": "string", "example": "9193265"},
+          "hash": {"type": "string"},
+          "confirmed": {"type": "boolean", "description": "False if fewer than PROXEUS_CONFIRMATION_DEPTH blocks were mined on top of the block"}
+        }
+      },
+      "JobRequest": {
+        "type": "object",
+        "required": ["address"],
+        "properties": {
+          "address": {"type": "string", "description": "Ethereum address"},
+          "block": {"type": "string", "description": "Same as the block parameter of GET /balances/{address}"},
+          "date": {"type": "string", "format": "date-time", "description": "Same as the date parameter of GET /balances/{address}"},
+          "tokens": {"type": "array", "items": {"type": "string"}},
+          "networks": {"type": "array", "items": {"type": "string"}},
+          "webhookUrl": {"type": "string", "description": "Receives the job with a POST request once it's done. The body is signed with PROXEUS_WEBHOOK_SECRET: the X-Signature-256 header is sha256= followed by the hex encoded HMAC-SHA256 of the body"}
+        }
+      },
+      "Job": {
+        "type": "object",
+        "properties": {
+          "id": {"type": "string"},
+          "status": {"type": "string", "enum": ["pending", "running", "done", "failed"]},
+          "request": {"$ref": "#/components/schemas/JobRequest"},
+          "webhookUrl": {"type": "string"},
+          "progress": {
+            "type": "object",
+            "description": "Blocks of which the Transfer logs were scanned, both are 0 if the balance provider doesn't scan logs",
+            "properties": {
+              "scannedBlocks": {"type": "integer"},
+              "totalBlocks": {"type": "integer"},
+              "processedLogs": {"type": "integer", "description": "Transfer logs handled so far"},
+              "etaSeconds": {"type": "integer", "description": "Estimated time left, 0 until it can be estimated"}
+            }
+          },
+          "result": {"$ref": "#/components/schemas/Balances"},
+          "error": {"type": "string"},
+          "webhookError": {"type": "string", "description": "Error of the last delivery attempt to the webhook"},
+          "webhookDelivered": {"type": "boolean"},
+          "createdAt": {"type": "string", "format": "date-time"},
+          "finishedAt": {"type": "string", "format": "date-time"}
+        }
+      },
+      "Balance": {
+        "type": "object",
+        "properties": {
+          "symbol": {"type": "string", "example": "XES"},
+          "value": {"type": "string", "description": "Balance in tokens, formatted according to PROXEUS_BALANCE_SCALE and PROXEUS_BALANCE_ROUNDING", "example": "1234.5"},
+          "baseUnits": {"type": "string", "description": "Exact balance in the smallest unit of the token", "example": "1234500000000000000000"},
+          "decimals": {"type": "integer", "example": 18},
+          "price": {"$ref": "#/components/schemas/Price"}
+        }
+      },
+      "Price": {
+        "type": "object",
+        "description": "Price of the token in PROXEUS_FIAT_CURRENCY at the date of the balances, omitted if the price oracle doesn't know it",
+        "properties": {
+          "currency": {"type": "string", "example": "CHF"},
+          "rate": {"type": "string", "description": "Price of one token", "example": "0.0042"},
+          "timestamp": {"type": "string", "format": "date-time", "description": "When the rate was observed by the price source"},
+          "value": {"type": "string", "description": "Value of the balance in the currency, with 2 decimals", "example": "5.18"}
+        }
+      }
+    }
+  }
+}
+`
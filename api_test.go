@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ProxeusApp/node-balance-retriever/service"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/labstack/echo"
+	"github.com/stretchr/testify/assert"
+)
+
+type apiBalanceServiceStub struct {
+	balances map[string]*service.TokenAmount
+	warnings []string
+	err      error
+}
+
+func (me *apiBalanceServiceStub) GetBalances(ctx context.Context, ethAddress string) (*service.TokenBalances, error) {
+	return me.result(nil)
+}
+
+func (me *apiBalanceServiceStub) GetBalancesAtDate(ctx context.Context, ethAddress string, date time.Time) (*service.TokenBalances, error) {
+	return me.result(&service.BalancesBlock{Number: big.NewInt(9193265), Hash: common.HexToHash("0x2"), Time: date})
+}
+
+func (me *apiBalanceServiceStub) GetBalancesAtBlock(ctx context.Context, ethAddress string, blockNumber *big.Int) (*service.TokenBalances, error) {
+	return me.result(&service.BalancesBlock{Number: blockNumber, Hash: common.HexToHash("0x1"), Confirmed: true})
+}
+
+func (me *apiBalanceServiceStub) result(block *service.BalancesBlock) (*service.TokenBalances, error) {
+	if me.err != nil {
+		return nil, me.err
+	}
+	return &service.TokenBalances{Balances: me.balances, Block: block, Warnings: me.warnings}, nil
+}
+
+func TestAPI(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jobs")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	xesBalance, _ := new(big.Int).SetString("1234500000000000000000", 10)
+	ethereumBalanceService = service.NewMultiChainBalanceService([]service.ChainBalanceService{
+		{Network: "mainnet", BalanceService: &apiBalanceServiceStub{balances: map[string]*service.TokenAmount{
+			"ETH": {Value: big.NewInt(0), Decimals: 18},
+			"XES": {
+				Value:    xesBalance,
+				Decimals: 18,
+				Price: &service.TokenPrice{
+					Rate:      big.NewRat(42, 10000),
+					Currency:  "CHF",
+					Timestamp: time.Date(2019, 12, 31, 0, 0, 0, 0, time.UTC),
+				},
+			},
+			"USDC": {Value: big.NewInt(2500000), Decimals: 6},
+		}, warnings: []string{"ignored token XES at 0x0000000000000000000000000000000000000bad"}}},
+		{Network: "polygon", BalanceService: &apiBalanceServiceStub{err: service.ErrEthplorerRateLimit}},
+	})
+	networkNames = []string{"mainnet", "polygon"}
+	balanceScale = defaultBalanceScale
+	balanceRoundingMode = service.RoundHalfUp
+
+	jobRunner, err = service.NewJobRunner(dir, runAPIJob, time.Minute, "", nil)
+	assert.Nil(t, err)
+
+	e := echo.New()
+	registerAPI(e, []string{"key1", "key2"})
+
+	address := "0xa017ac5fac5941f95010b12570b812c974469c2c"
+	checksumAddress := common.HexToAddress(address).Hex()
+
+	t.Run("ShouldRequireAPIKey", func(t *testing.T) {
+		rec := apiRequest(e, http.MethodGet, "/api/v1/balances/"+address, "", "")
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+		rec = apiRequest(e, http.MethodGet, "/api/v1/balances/"+address, "wrong key", "")
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+		rec = apiRequest(e, http.MethodPost, "/api/v1/jobs", "wrong key", `{"address":"`+address+`"}`)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+		rec = apiRequest(e, http.MethodGet, "/api/v1/jobs/1", "", "")
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+		// The OpenAPI document is public
+		rec = apiRequest(e, http.MethodGet, "/api/v1/openapi.json", "", "")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.True(t, json.Valid(rec.Body.Bytes()))
+	})
+
+	t.Run("ShouldRejectInvalidRequest", func(t *testing.T) {
+		for target, expectedError := range map[string]string{
+			"/api/v1/balances/0x1234":                                                             "invalid address",
+			"/api/v1/balances/" + address + "?networks=ropsten":                                   "network ropsten isn't configured",
+			"/api/v1/balances/" + address + "?block=100":                                          "block requires a single network",
+			"/api/v1/balances/" + address + "?block=-1&networks=mainnet":                          "invalid block",
+			"/api/v1/balances/" + address + "?date=2019-12-31":                                    "invalid date",
+			"/api/v1/balances/" + address + "?block=1&date=2019-12-31T23:59:59Z&networks=mainnet": "can't be combined",
+		} {
+			rec := apiRequest(e, http.MethodGet, target, "key1", "")
+			assert.Equal(t, http.StatusBadRequest, rec.Code, target)
+			assert.Contains(t, rec.Body.String(), expectedError, target)
+		}
+	})
+
+	t.Run("ShouldReturnBalances", func(t *testing.T) {
+		rec := apiRequest(e, http.MethodGet, "/api/v1/balances/"+address+"?networks=mainnet", "key2", "")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.JSONEq(t, `{
+			"address": "`+checksumAddress+`",
+			"chains": [{
+				"network": "mainnet",
+				"balances": [
+					{"symbol": "ETH", "value": "0", "baseUnits": "0", "decimals": 18},
+					{"symbol": "USDC", "value": "2.5", "baseUnits": "2500000", "decimals": 6},
+					{
+						"symbol": "XES",
+						"value": "1234.5",
+						"baseUnits": "1234500000000000000000",
+						"decimals": 18,
+						"price": {"currency": "CHF", "rate": "0.0042", "timestamp": "2019-12-31T00:00:00Z", "value": "5.18"}
+					}
+				],
+				"warnings": ["ignored token XES at 0x0000000000000000000000000000000000000bad"]
+			}]
+		}`, rec.Body.String())
+	})
+
+	t.Run("ShouldFormatBalancesWithScale", func(t *testing.T) {
+		balanceScale, balanceRoundingMode = 0, service.RoundDown
+		defer func() { balanceScale, balanceRoundingMode = defaultBalanceScale, service.RoundHalfUp }()
+
+		rec := apiRequest(e, http.MethodGet, "/api/v1/balances/"+address+"?networks=mainnet&tokens=XES,USDC", "key1", "")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		var response apiBalancesResponse
+		err := json.Unmarshal(rec.Body.Bytes(), &response)
+		assert.Nil(t, err)
+		assert.Len(t, response.Chains, 1)
+		assert.Len(t, response.Chains[0].Balances, 2)
+		assert.Equal(t, "2", response.Chains[0].Balances[0].Value)
+		assert.Equal(t, "1234", response.Chains[0].Balances[1].Value)
+		// Fiat values always have 2 decimals
+		assert.Equal(t, "5.18", response.Chains[0].Balances[1].Price.Value)
+	})
+
+	t.Run("ShouldReturnBalancesAtBlock", func(t *testing.T) {
+		rec := apiRequest(e, http.MethodGet, "/api/v1/balances/"+address+"?networks=mainnet&block=9193265&tokens=ETH", "key1", "")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		var response apiBalancesResponse
+		err := json.Unmarshal(rec.Body.Bytes(), &response)
+		assert.Nil(t, err)
+		assert.Equal(t, &apiBlock{Number: "9193265", Hash: common.HexToHash("0x1").Hex(), Confirmed: true}, response.Chains[0].Block)
+	})
+
+	t.Run("ShouldReportBalanceProviderError", func(t *testing.T) {
+		rec := apiRequest(e, http.MethodGet, "/api/v1/balances/"+address, "key1", "")
+		assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+		assert.Contains(t, rec.Body.String(), "network polygon")
+	})
+
+	t.Run("ShouldRunJob", func(t *testing.T) {
+		rec := apiRequest(e, http.MethodPost, "/api/v1/jobs", "key1", `{"address":"`+address+`","networks":["mainnet"],"tokens":["USDC"]}`)
+		assert.Equal(t, http.StatusAccepted, rec.Code)
+		var job service.Job
+		err := json.Unmarshal(rec.Body.Bytes(), &job)
+		assert.Nil(t, err)
+		assert.NotEmpty(t, job.ID)
+
+		job = waitForAPIJob(t, e, job.ID)
+		assert.Equal(t, service.JobDone, job.Status)
+		assert.JSONEq(t, `{
+			"address": "`+checksumAddress+`",
+			"chains": [{
+				"network": "mainnet",
+				"balances": [{"symbol": "USDC", "value": "2.5", "baseUnits": "2500000", "decimals": 6}],
+				"warnings": ["ignored token XES at 0x0000000000000000000000000000000000000bad"]
+			}]
+		}`, string(job.Result))
+	})
+
+	t.Run("ShouldReportFailedJob", func(t *testing.T) {
+		rec := apiRequest(e, http.MethodPost, "/api/v1/jobs", "key1", `{"address":"`+address+`","networks":["polygon"]}`)
+		assert.Equal(t, http.StatusAccepted, rec.Code)
+		var job service.Job
+		err := json.Unmarshal(rec.Body.Bytes(), &job)
+		assert.Nil(t, err)
+
+		job = waitForAPIJob(t, e, job.ID)
+		assert.Equal(t, service.JobFailed, job.Status)
+		assert.Contains(t, job.Error, "network polygon")
+	})
+
+	t.Run("ShouldRejectInvalidJob", func(t *testing.T) {
+		for _, body := range []string{
+			`{"address":"0x1234"}`,
+			`{"address":"` + address + `","blocks":"1"}`,
+			`{"address":"` + address + `","networks":["ropsten"]}`,
+			`{"address":"` + address + `","webhookUrl":"http://127.0.0.1/hook"}`,
+		} {
+			rec := apiRequest(e, http.MethodPost, "/api/v1/jobs", "key1", body)
+			assert.Equal(t, http.StatusBadRequest, rec.Code, body)
+		}
+	})
+
+	t.Run("ShouldReturnNotFoundForUnknownJob", func(t *testing.T) {
+		rec := apiRequest(e, http.MethodGet, "/api/v1/jobs/unknown", "key1", "")
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+}
+
+func apiRequest(e *echo.Echo, method, target, apiKey, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if len(apiKey) != 0 {
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+apiKey)
+	}
+	if len(body) != 0 {
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+// Polls the job through the API until it's done or failed
+func waitForAPIJob(t *testing.T, e *echo.Echo, id string) service.Job {
+	deadline := time.Now().Add(time.Second * 5)
+	for {
+		rec := apiRequest(e, http.MethodGet, fmt.Sprintf("/api/v1/jobs/%s", id), "key1", "")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		var job service.Job
+		err := json.Unmarshal(rec.Body.Bytes(), &job)
+		assert.Nil(t, err)
+		if job.Status == service.JobDone || job.Status == service.JobFailed || time.Now().After(deadline) {
+			return job
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
@@ -0,0 +1,19 @@
+package blockchain
+
+import (
+	"math/big"
+)
+
+// Subset of the Chainlink AggregatorV3Interface ABI (https://docs.chain.link/data-feeds/api-reference), implemented by
+// the proxies of the price feeds
+const ChainlinkAggregatorABI = "[ { \"inputs\": [], \"name\": \"decimals\", \"outputs\": [ { \"internalType\": \"uint8\", \"name\": \"\", \"type\": \"uint8\" } ], \"stateMutability\": \"view\", \"type\": \"function\" }, { \"inputs\": [ { \"internalType\": \"uint80\", \"name\": \"_roundId\", \"type\": \"uint80\" } ], \"name\": \"getRoundData\", \"outputs\": [ { \"internalType\": \"uint80\", \"name\": \"roundId\", \"type\": \"uint80\" }, { \"internalType\": \"int256\", \"name\": \"answer\", \"type\": \"int256\" }, { \"internalType\": \"uint256\", \"name\": \"startedAt\", \"type\": \"uint256\" }, { \"internalType\": \"uint256\", \"name\": \"updatedAt\", \"type\": \"uint256\" }, { \"internalType\": \"uint80\", \"name\": \"answeredInRound\", \"type\": \"uint80\" } ], \"stateMutability\": \"view\", \"type\": \"function\" }, { \"inputs\": [], \"name\": \"latestRoundData\", \"outputs\": [ { \"internalType\": \"uint80\", \"name\": \"roundId\", \"type\": \"uint80\" }, { \"internalType\": \"int256\", \"name\": \"answer\", \"type\": \"int256\" }, { \"internalType\": \"uint256\", \"name\": \"startedAt\", \"type\": \"uint256\" }, { \"internalType\": \"uint256\", \"name\": \"updatedAt\", \"type\": \"uint256\" }, { \"internalType\": \"uint80\", \"name\": \"answeredInRound\", \"type\": \"uint80\" } ], \"stateMutability\": \"view\", \"type\": \"function\" } ]"
+
+// Output of "latestRoundData" and "getRoundData". The round ID of a proxy is the phase of its aggregator in the 16
+// highest bits, followed by the round ID of the aggregator in the 64 lowest bits.
+type ChainlinkRoundData struct {
+	RoundId         *big.Int
+	Answer          *big.Int
+	StartedAt       *big.Int
+	UpdatedAt       *big.Int
+	AnsweredInRound *big.Int
+}
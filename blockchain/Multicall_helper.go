@@ -0,0 +1,23 @@
+package blockchain
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Subset of the Multicall3 ABI (https://github.com/mds1/multicall), compatible with the original Multicall contract
+const MulticallABI = "[ { \"inputs\": [ { \"components\": [ { \"internalType\": \"address\", \"name\": \"target\", \"type\": \"address\" }, { \"internalType\": \"bytes\", \"name\": \"callData\", \"type\": \"bytes\" } ], \"internalType\": \"struct Multicall3.Call[]\", \"name\": \"calls\", \"type\": \"tuple[]\" } ], \"name\": \"aggregate\", \"outputs\": [ { \"internalType\": \"uint256\", \"name\": \"blockNumber\", \"type\": \"uint256\" }, { \"internalType\": \"bytes[]\", \"name\": \"returnData\", \"type\": \"bytes[]\" } ], \"stateMutability\": \"payable\", \"type\": \"function\" }, { \"inputs\": [ { \"internalType\": \"address\", \"name\": \"addr\", \"type\": \"address\" } ], \"name\": \"getEthBalance\", \"outputs\": [ { \"internalType\": \"uint256\", \"name\": \"balance\", \"type\": \"uint256\" } ], \"stateMutability\": \"view\", \"type\": \"function\" } ]"
+
+// Same address on every network, see https://github.com/mds1/multicall#deployments
+const Multicall3Address = "0xcA11bde05977b3631167028862bE2a173976CA11"
+
+type MulticallCall struct {
+	Target   common.Address
+	CallData []byte
+}
+
+type MulticallAggregateResult struct {
+	BlockNumber *big.Int
+	ReturnData  [][]byte
+}
@@ -0,0 +1,86 @@
+package blockchain
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Built-in settings of an Ethereum network
+type Network struct {
+	Name    string
+	ChainID uint64
+	// Symbol of the native asset of the network, always 18 decimals
+	NativeSymbol string
+	// Hash of block 0, to make sure a client is connected to this network and not to a fork sharing its chain ID
+	GenesisHash common.Hash
+	// Infura endpoint, the API key has to be appended
+	DefaultEthClientUrl string
+	// Empty if there's no Multicall contract on the network
+	MulticallAddress string
+	// Contract address -> symbol of the tokens supported by default, checksummed (EIP-55)
+	Tokens map[string]string
+}
+
+var Networks = map[string]Network{
+	"mainnet": {
+		Name:                "mainnet",
+		ChainID:             1,
+		NativeSymbol:        "ETH",
+		GenesisHash:         common.HexToHash("0xd4e56740f876aef8c010b86a40d5f56745a118d0906a34e69aec8c0db1cb8fa3"),
+		DefaultEthClientUrl: "https://mainnet.infura.io/v3/",
+		MulticallAddress:    Multicall3Address,
+		Tokens: map[string]string{
+			"0xA017ac5faC5941f95010b12570B812C974469c2C": "XES",
+			"0x9f8F72aA9304c8B593d555F12eF6589cC3A579A2": "MKR",
+			"0x0D8775F648430679A709E98d2b0Cb6250d2887EF": "BAT",
+			"0xd26114cd6EE289AccF82350c8d8487fedB8A0C07": "OMG",
+			"0xE41d2489571d322189246DaFA5ebDe1F4699F498": "ZRX",
+			"0xF629cBd94d3791C9250152BD8dfBDF380E2a3B9c": "ENJ",
+		},
+	},
+	// Other EVM chains and testnets have no default token, use a token registry or a token list
+	"polygon": {
+		Name:                "polygon",
+		ChainID:             137,
+		NativeSymbol:        "MATIC",
+		GenesisHash:         common.HexToHash("0xa9c28ce2141b56c474f1dc504bee9b01eb1bd7d1a507580d5519d4437a97de1b"),
+		DefaultEthClientUrl: "https://polygon-mainnet.infura.io/v3/",
+		MulticallAddress:    Multicall3Address,
+		Tokens:              map[string]string{},
+	},
+	"bsc": {
+		Name:                "bsc",
+		ChainID:             56,
+		NativeSymbol:        "BNB",
+		GenesisHash:         common.HexToHash("0x0d21840abff46b96c84b2ac9e10e4f5cdaeb5693cb665db62a2f3b02fc08b57b"),
+		DefaultEthClientUrl: "https://bsc-mainnet.infura.io/v3/",
+		MulticallAddress:    Multicall3Address,
+		Tokens:              map[string]string{},
+	},
+	"arbitrum": {
+		Name:                "arbitrum",
+		ChainID:             42161,
+		NativeSymbol:        "ETH",
+		GenesisHash:         common.HexToHash("0x7ee576b35482195fc49205cec9af72ce14f003b9ae69f6ba0faef4514be8b442"),
+		DefaultEthClientUrl: "https://arbitrum-mainnet.infura.io/v3/",
+		MulticallAddress:    Multicall3Address,
+		Tokens:              map[string]string{},
+	},
+	"sepolia": {
+		Name:                "sepolia",
+		ChainID:             11155111,
+		NativeSymbol:        "ETH",
+		GenesisHash:         common.HexToHash("0x25a5cc106eea7138acab33231d7160d69cb777ee0c2c553fcddf5138993e6dd9"),
+		DefaultEthClientUrl: "https://sepolia.infura.io/v3/",
+		MulticallAddress:    Multicall3Address,
+		Tokens:              map[string]string{},
+	},
+	"holesky": {
+		Name:                "holesky",
+		ChainID:             17000,
+		NativeSymbol:        "ETH",
+		GenesisHash:         common.HexToHash("0xb5f7f912443c940f21fd611f12828d75b534364ed9e95ca4e307729a4661bde4"),
+		DefaultEthClientUrl: "https://holesky.infura.io/v3/",
+		MulticallAddress:    Multicall3Address,
+		Tokens:              map[string]string{},
+	},
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/ProxeusApp/node-balance-retriever/service"
+	"github.com/labstack/echo"
+)
+
+// Config form of a node instance. The form is posted to the URL it was loaded from, keeping the auth query parameter.
+var configFormTemplate = template.Must(template.New("config").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Retrieve Token Balances</title></head>
+<body>
+<form method="post">
+	{{if .Message}}<p>{{.Message}}</p>{{end}}
+	<fieldset>
+		<legend>Networks (all if none is selected)</legend>
+		{{range .Networks}}<label><input type="checkbox" name="networks" value="{{.Name}}"{{if .Selected}} checked{{end}}> {{.Name}}</label><br>
+		{{end}}
+	</fieldset>
+	<p><label>Tokens, comma separated (all if empty)<br><input type="text" name="tokens" value="{{.Tokens}}"></label></p>
+	<p><label>Balance date, used if the workflow data has no balanceDate (e.g. 2019-12-31T23:59:59+01:00)<br><input type="text" name="balanceDate" value="{{.BalanceDate}}"></label></p>
+	<p><label>Path of the address in the workflow data (default ethAddress, e.g. customer.wallets.0)<br><input type="text" name="addressPath" value="{{.AddressPath}}"></label></p>
+	<p><label>Paths of several address fields, comma separated, used instead of the path above if set<br><input type="text" name="addressPaths" value="{{.AddressPaths}}"></label></p>
+	<p><label>Path of the balance date in the workflow data (default balanceDate)<br><input type="text" name="balanceDatePath" value="{{.BalanceDatePath}}"></label></p>
+	<p><label>Output sub-object, e.g. balances for balances.XES (top level if empty)<br><input type="text" name="outputObject" value="{{.OutputObject}}"></label></p>
+	<p><label>Output field prefix<br><input type="text" name="outputPrefix" value="{{.OutputPrefix}}"></label></p>
+	<p><label><input type="checkbox" name="failOnOverwrite" value="true"{{if .FailOnOverwrite}} checked{{end}}> Fail instead of overwriting existing fields</label></p>
+	<p><label><input type="checkbox" name="fiatValues" value="true"{{if .FiatValues}} checked{{end}}> Add the fiat values of the balances and their total (e.g. XES_CHF, total_CHF)</label></p>
+	<p><label>Output field names, one "field=name" per line (e.g. XES=xesBalance)<br><textarea name="outputFields" rows="5" cols="40">{{.OutputFields}}</textarea></label></p>
+	<input type="submit" value="Save">
+</form>
+</body>
+</html>
+`))
+
+type (
+	configForm struct {
+		Message         string
+		Networks        []configFormNetwork
+		Tokens          string
+		BalanceDate     string
+		OutputFields    string
+		AddressPath     string
+		AddressPaths    string
+		BalanceDatePath string
+		OutputObject    string
+		OutputPrefix    string
+		FailOnOverwrite bool
+		FiatValues      bool
+	}
+
+	configFormNetwork struct {
+		Name     string
+		Selected bool
+	}
+)
+
+// Returns the config of the node instance as JSON if requested, the config form otherwise
+func getConfig(c echo.Context) error {
+	config, err := nodeConfigStore.Get(c.Param("id"))
+	if err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+
+	if acceptsJSON(c) {
+		return c.JSON(http.StatusOK, config)
+	}
+	return renderConfigForm(c, config, "")
+}
+
+// Saves the config of the node instance, posted either as JSON or from the config form
+func setConfig(c echo.Context) error {
+	var config service.NodeConfig
+	if strings.HasPrefix(c.Request().Header.Get(echo.HeaderContentType), echo.MIMEApplicationJSON) {
+		decoder := json.NewDecoder(c.Request().Body)
+		decoder.DisallowUnknownFields()
+		err := decoder.Decode(&config)
+		if err != nil {
+			return c.String(http.StatusBadRequest, fmt.Sprintf("[taxreporter][config] invalid config: %v", err))
+		}
+	} else {
+		var err error
+		config, err = parseConfigForm(c)
+		if err != nil {
+			return c.String(http.StatusBadRequest, fmt.Sprintf("[taxreporter][config] invalid config: %v", err))
+		}
+	}
+
+	_, err := ethereumBalanceService.Select(config.Networks)
+	if err != nil {
+		return c.String(http.StatusBadRequest, fmt.Sprintf("[taxreporter][config] invalid config: %v", err))
+	}
+	err = nodeConfigStore.Save(c.Param("id"), config)
+	if err != nil {
+		return c.String(http.StatusBadRequest, fmt.Sprintf("[taxreporter][config] invalid config: %v", err))
+	}
+
+	if acceptsJSON(c) {
+		return c.JSON(http.StatusOK, config)
+	}
+	return renderConfigForm(c, config, "Saved")
+}
+
+// The node instance was removed from its workflow
+func removeConfig(c echo.Context) error {
+	err := nodeConfigStore.Delete(c.Param("id"))
+	if err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+// The workflow execution is over, the config is loaded again on the next execution
+func closeConfig(c echo.Context) error {
+	nodeConfigStore.Close(c.Param("id"))
+	return c.NoContent(http.StatusOK)
+}
+
+func acceptsJSON(c echo.Context) bool {
+	return strings.Contains(c.Request().Header.Get(echo.HeaderAccept), echo.MIMEApplicationJSON)
+}
+
+func parseConfigForm(c echo.Context) (service.NodeConfig, error) {
+	params, err := c.FormParams()
+	if err != nil {
+		return service.NodeConfig{}, err
+	}
+
+	config := service.NodeConfig{
+		Networks:        params["networks"],
+		BalanceDate:     strings.TrimSpace(params.Get("balanceDate")),
+		AddressPath:     strings.TrimSpace(params.Get("addressPath")),
+		BalanceDatePath: strings.TrimSpace(params.Get("balanceDatePath")),
+		OutputObject:    strings.TrimSpace(params.Get("outputObject")),
+		OutputPrefix:    params.Get("outputPrefix"),
+		FailOnOverwrite: params.Get("failOnOverwrite") == "true",
+		FiatValues:      params.Get("fiatValues") == "true",
+	}
+	config.Tokens = splitList(params.Get("tokens"))
+	config.AddressPaths = splitList(params.Get("addressPaths"))
+	for _, line := range strings.Split(params.Get("outputFields"), "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			return config, fmt.Errorf("output field %q isn't formatted as field=name", line)
+		}
+		if config.OutputFields == nil {
+			config.OutputFields = make(map[string]string)
+		}
+		config.OutputFields[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	return config, nil
+}
+
+func renderConfigForm(c echo.Context, config service.NodeConfig, message string) error {
+	form := configForm{
+		Message:         message,
+		Tokens:          strings.Join(config.Tokens, ", "),
+		BalanceDate:     config.BalanceDate,
+		AddressPath:     config.AddressPath,
+		AddressPaths:    strings.Join(config.AddressPaths, ", "),
+		BalanceDatePath: config.BalanceDatePath,
+		OutputObject:    config.OutputObject,
+		OutputPrefix:    config.OutputPrefix,
+		FailOnOverwrite: config.FailOnOverwrite,
+		FiatValues:      config.FiatValues,
+	}
+	for _, network := range networkNames {
+		selected := false
+		for _, selectedNetwork := range config.Networks {
+			selected = selected || selectedNetwork == network
+		}
+		form.Networks = append(form.Networks, configFormNetwork{Name: network, Selected: selected})
+	}
+	var outputFields []string
+	for field, name := range config.OutputFields {
+		outputFields = append(outputFields, field+"="+name)
+	}
+	sort.Strings(outputFields)
+	form.OutputFields = strings.Join(outputFields, "\n")
+
+	var html strings.Builder
+	err := configFormTemplate.Execute(&html, form)
+	if err != nil {
+		return c.String(http.StatusInternalServerError, err.Error())
+	}
+	return c.HTML(http.StatusOK, html.String())
+}
+
+// Splits a comma separated list, ignoring empty items
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); len(item) != 0 {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ProxeusApp/node-balance-retriever/service"
+	"github.com/labstack/echo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigHandlers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "node-config")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	nodeConfigStore, err = service.NewNodeConfigStore(dir)
+	assert.Nil(t, err)
+	// Only the network names are needed to validate the configs
+	ethereumBalanceService = service.NewMultiChainBalanceService([]service.ChainBalanceService{
+		{Network: "mainnet"},
+		{Network: "polygon"},
+	})
+	networkNames = []string{"mainnet", "polygon"}
+
+	// Same routes as in main, without the JWT authentication
+	e := echo.New()
+	g := e.Group("/node/:id")
+	g.GET("/config", getConfig)
+	g.POST("/config", setConfig)
+	g.POST("/remove", removeConfig)
+	g.POST("/close", closeConfig)
+
+	t.Run("ShouldRejectInvalidNodeID", func(t *testing.T) {
+		for _, id := range []string{"node.1", "..", "node%201"} {
+			rec := configRequest(e, http.MethodGet, "/node/"+id+"/config", "", "")
+			assert.Equal(t, http.StatusBadRequest, rec.Code, id)
+
+			rec = configRequest(e, http.MethodPost, "/node/"+id+"/config", echo.MIMEApplicationJSON, `{"tokens":["XES"]}`)
+			assert.Equal(t, http.StatusBadRequest, rec.Code, id)
+
+			rec = configRequest(e, http.MethodPost, "/node/"+id+"/remove", "", "")
+			assert.Equal(t, http.StatusBadRequest, rec.Code, id)
+		}
+
+		files, err := ioutil.ReadDir(dir)
+		assert.Nil(t, err)
+		assert.Empty(t, files)
+	})
+
+	t.Run("ShouldReturnEmptyConfigIfNeverSaved", func(t *testing.T) {
+		assert.Equal(t, service.NodeConfig{}, getJSONConfig(t, e, "node1"))
+	})
+
+	t.Run("ShouldRejectUnknownNetwork", func(t *testing.T) {
+		rec := configRequest(e, http.MethodPost, "/node/node1/config", echo.MIMEApplicationJSON, `{"networks":["ropsten"]}`)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Contains(t, rec.Body.String(), "network ropsten isn't configured")
+
+		assert.Equal(t, service.NodeConfig{}, getJSONConfig(t, e, "node1"))
+	})
+
+	t.Run("ShouldRejectUnknownField", func(t *testing.T) {
+		rec := configRequest(e, http.MethodPost, "/node/node1/config", echo.MIMEApplicationJSON, `{"token":"XES"}`)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+		assert.Equal(t, service.NodeConfig{}, getJSONConfig(t, e, "node1"))
+	})
+
+	t.Run("ShouldRoundTripJSONConfig", func(t *testing.T) {
+		config := service.NodeConfig{
+			Networks:     []string{"polygon"},
+			Tokens:       []string{"USDC", "MATIC"},
+			BalanceDate:  "2019-12-31T23:59:59+01:00",
+			OutputFields: map[string]string{"USDC": "usdcBalance"},
+			OutputObject: "balances",
+			FiatValues:   true,
+		}
+		body, err := json.Marshal(config)
+		assert.Nil(t, err)
+
+		rec := configRequest(e, http.MethodPost, "/node/node1/config", echo.MIMEApplicationJSON, string(body))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, config, getJSONConfig(t, e, "node1"))
+
+		// Closing the instance only releases the config from memory
+		rec = configRequest(e, http.MethodPost, "/node/node1/close", "", "")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, config, getJSONConfig(t, e, "node1"))
+	})
+
+	t.Run("ShouldRoundTripFormConfig", func(t *testing.T) {
+		form := url.Values{
+			"networks":        {"mainnet", "polygon"},
+			"tokens":          {"XES, ETH"},
+			"addressPath":     {" customer.wallets.0 "},
+			"outputPrefix":    {"balance_"},
+			"failOnOverwrite": {"true"},
+			"outputFields":    {"XES = xesBalance\n\nETH=ethBalance\n"},
+		}
+		rec := configRequest(e, http.MethodPost, "/node/node2/config", echo.MIMEApplicationForm, form.Encode())
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), "Saved")
+
+		assert.Equal(t, service.NodeConfig{
+			Networks:        []string{"mainnet", "polygon"},
+			Tokens:          []string{"XES", "ETH"},
+			AddressPath:     "customer.wallets.0",
+			OutputPrefix:    "balance_",
+			FailOnOverwrite: true,
+			OutputFields:    map[string]string{"XES": "xesBalance", "ETH": "ethBalance"},
+		}, getJSONConfig(t, e, "node2"))
+
+		// The form is rendered with the saved config
+		rec = configRequest(e, http.MethodGet, "/node/node2/config", "", "")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), `value="XES, ETH"`)
+		assert.Contains(t, rec.Body.String(), "ETH=ethBalance\nXES=xesBalance")
+	})
+
+	t.Run("ShouldRemoveConfig", func(t *testing.T) {
+		rec := configRequest(e, http.MethodPost, "/node/node3/config", echo.MIMEApplicationJSON, `{"tokens":["XES"]}`)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		_, err := os.Stat(filepath.Join(dir, "node3.json"))
+		assert.Nil(t, err)
+
+		rec = configRequest(e, http.MethodPost, "/node/node3/remove", "", "")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, service.NodeConfig{}, getJSONConfig(t, e, "node3"))
+		_, err = os.Stat(filepath.Join(dir, "node3.json"))
+		assert.True(t, os.IsNotExist(err))
+
+		// Removing it again is a no-op
+		rec = configRequest(e, http.MethodPost, "/node/node3/remove", "", "")
+		assert.Equal(t, http.StatusOK, rec.Code)
+	})
+}
+
+func configRequest(e *echo.Echo, method, target, contentType, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if len(contentType) != 0 {
+		req.Header.Set(echo.HeaderContentType, contentType)
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func getJSONConfig(t *testing.T, e *echo.Echo, id string) service.NodeConfig {
+	req := httptest.NewRequest(http.MethodGet, "/node/"+id+"/config", nil)
+	req.Header.Set(echo.HeaderAccept, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var config service.NodeConfig
+	err := json.Unmarshal(rec.Body.Bytes(), &config)
+	assert.Nil(t, err)
+	return config
+}
@@ -9,4 +9,5 @@ require (
 	github.com/proxeusapp/node-go v1.0.1 // indirect
 	github.com/stretchr/testify v1.5.1
 	github.com/valyala/fasttemplate v1.1.0 // indirect
+	go.etcd.io/bbolt v1.3.6
 )
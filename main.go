@@ -1,34 +1,74 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/ethereum/go-ethereum/common"
 
 	externalnode "github.com/ProxeusApp/node-go"
 
+	"github.com/ProxeusApp/node-balance-retriever/blockchain"
 	"github.com/ProxeusApp/node-balance-retriever/service"
 	"github.com/labstack/echo"
 	"github.com/labstack/echo/middleware"
 )
 
 const (
-	defaultServiceName = "Retrieve Token Balances"
-	defaultServicePort = "8012"
-	defaultJWTSecret   = "my secret 2"
-	defaultProxeusUrl  = "http://127.0.0.1:1323"
-	defaultAuthkey     = "auth"
+	defaultServiceName     = "Retrieve Token Balances"
+	defaultServicePort     = "8012"
+	defaultJWTSecret       = "my secret 2"
+	defaultProxeusUrl      = "http://127.0.0.1:1323"
+	defaultAuthkey         = "auth"
+	defaultBalanceProvider = balanceProviderEthplorer
+	defaultConfirmations   = 12
+	defaultNetwork         = "mainnet"
+	defaultBalanceScale    = -1 // All the decimals of the token
+	defaultBalanceRounding = "half-up"
+	defaultNodeConfigDir   = "node-config"
+	defaultJobsDir         = "jobs"
+	defaultJobTimeout      = time.Hour * 24
+
+	balanceProviderEthplorer = "ethplorer"
+	balanceProviderRPC       = "rpc"
+
+	defaultRPCBalanceMode = rpcBalanceModeCall
+	rpcBalanceModeCall    = "call"
+	rpcBalanceModeLogs    = "logs"
+
+	priceOracleNone      = "none"
+	priceOracleEthplorer = "ethplorer"
+	priceOracleCSV       = "csv"
+	priceOracleHTTP      = "http"
+	priceOracleChainlink = "chainlink"
+	defaultFiatCurrency  = "CHF"
+	// Prices missing from the oracles are derived from the prices in this currency, e.g. ETH/CHF from ETH/USD and CHF/USD
+	crossRateCurrency = "USD"
+
+	// Fractional digits of the fiat values, e.g. centimes for CHF
+	fiatScale = 2
 )
 
 var (
-	ethereumBalanceService service.EthereumBalanceService
+	ethereumBalanceService service.MultiChainBalanceService
+	nodeConfigStore        service.NodeConfigStore
+	jobRunner              service.JobRunner
+	networkNames           []string // Configured networks, in the order of NETWORK
+	balanceScale           = defaultBalanceScale
+	balanceRoundingMode    service.RoundingMode
 	errCastingEthAddress   = errors.New("[taxreporter][next] casting error ethAddress")
+	errCastingBalanceDate  = errors.New("[taxreporter][next] casting error balanceDate")
 )
 
 func main() {
@@ -60,55 +100,85 @@ func main() {
 	fmt.Println("#######################################################")
 	fmt.Println()
 
-	xesAddress := os.Getenv("PROXEUS_XES_ADDRESS")
-	if len(xesAddress) == 0 {
-		//xesAddress = "0xA017ac5faC5941f95010b12570B812C974469c2C" //mainnet
-		xesAddress = "0x84E0b37e8f5B4B86d5d299b0B0e33686405A3919" //ropsten
+	// Comma separated, e.g. "mainnet,polygon"
+	networksEnv := os.Getenv("NETWORK")
+	if len(networksEnv) == 0 {
+		networksEnv = defaultNetwork
 	}
-
-	mkrAddress := os.Getenv("PROXEUS_MKR_ADDRESS")
-	if len(mkrAddress) == 0 {
-		//mkrAddress = "0x9f8F72aA9304c8B593d555F12eF6589cC3A579A2" //mainnet
-		mkrAddress = "0x710129558E8ffF5caB9c0c9c43b99d79Ed864B99" //ropsten
+	var networks []blockchain.Network
+	for _, networkName := range strings.Split(networksEnv, ",") {
+		network, ok := blockchain.Networks[strings.TrimSpace(networkName)]
+		if !ok {
+			log.Fatal("[taxreporter][run] unknown NETWORK: ", networkName)
+		}
+		networks = append(networks, network)
+		networkNames = append(networkNames, network.Name)
 	}
+	multiNetwork := len(networks) > 1
 
-	batAddress := os.Getenv("PROXEUS_BAT_ADDRESS")
-	if len(batAddress) == 0 {
-		//batAddress = "" //mainnet
-		batAddress = "0x60B10C134088ebD63f80766874e2Cade05fc987B" //ropsten
+	// Tokens are listed in the registry file and/or the token list. Without any, the default tokens of each network are
+	// used.
+	var tokenRegistry *service.TokenRegistry
+	tokenRegistryPath := os.Getenv("PROXEUS_TOKEN_REGISTRY")
+	tokenListPath := os.Getenv("PROXEUS_TOKEN_LIST")
+	if len(tokenRegistryPath) != 0 || len(tokenListPath) != 0 {
+		var err error
+		tokenRegistry, err = loadTokenRegistry(tokenRegistryPath, tokenListPath, networks)
+		if err != nil {
+			log.Fatal("[taxreporter][run] token registry err: ", err.Error())
+		}
 	}
 
-	omgAddress := os.Getenv("PROXEUS_OMG_ADDRESS")
-	if len(omgAddress) == 0 {
-		//omgAddress = "" //mainnet
-		omgAddress = "0x9820B36a37Af9389a23ACfb7988C0ee6837763b6" //ropsten
+	if scale := os.Getenv("PROXEUS_BALANCE_SCALE"); len(scale) != 0 {
+		var err error
+		balanceScale, err = strconv.Atoi(scale)
+		if err != nil {
+			log.Fatal("[taxreporter][run] invalid PROXEUS_BALANCE_SCALE: ", err.Error())
+		}
 	}
-
-	zrxAddress := os.Getenv("PROXEUS_ZRX_ADDRESS")
-	if len(zrxAddress) == 0 {
-		//zrxAddress = "" //mainnet
-		zrxAddress = "0xA8E9Fa8f91e5Ae138C74648c9C304F1C75003A8D" //ropsten
+	balanceRounding := os.Getenv("PROXEUS_BALANCE_ROUNDING")
+	if len(balanceRounding) == 0 {
+		balanceRounding = defaultBalanceRounding
 	}
-
-	enjAddress := os.Getenv("PROXEUS_ENJ_ADDRESS")
-	if len(enjAddress) == 0 {
-		//enjAddress = "" //mainnet
-		enjAddress = "0x81Ec0eD50441fc3d1d63763F27b24081E5b516d5" //ropsten
+	var err error
+	balanceRoundingMode, err = service.ParseRoundingMode(balanceRounding)
+	if err != nil {
+		log.Fatal("[taxreporter][run] invalid PROXEUS_BALANCE_ROUNDING: ", err.Error())
 	}
 
-	//make sure to add new contract addresses with checksum (EIP-55)
-	tokensMap := map[string]string{
-		common.HexToAddress(xesAddress).String(): "XES",
-		common.HexToAddress(mkrAddress).String(): "MKR",
-		common.HexToAddress(batAddress).String(): "BAT",
-		common.HexToAddress(omgAddress).String(): "OMG",
-		common.HexToAddress(zrxAddress).String(): "ZRX",
-		common.HexToAddress(enjAddress).String(): "ENJ",
-	}
+	var chainBalanceServices []service.ChainBalanceService
+	for _, network := range networks {
+		config, err := readBalanceServiceConfig(network, multiNetwork, tokenRegistry)
+		if err != nil {
+			log.Fatal("[taxreporter][run] ", err.Error())
+		}
 
-	balanceService := service.NewEthplorerBalanceService(tokensMap)
+		balanceService, blockResolver, tokenDecimalsResolver, err := newBalanceService(config)
+		if err != nil {
+			log.Fatalf("[taxreporter][run] balance provider of network %s err: %s", network.Name, err.Error())
+		}
+		priceOracle, err := newPriceOracle(config)
+		if err != nil {
+			log.Fatalf("[taxreporter][run] price oracle of network %s err: %s", network.Name, err.Error())
+		}
+		chainBalanceService := service.ChainBalanceService{Network: network.Name}
+		if config.priceOracle == priceOracleNone {
+			chainBalanceService.BalanceService = service.NewEthereumBalanceService(balanceService, blockResolver, tokenDecimalsResolver, network.NativeSymbol)
+		} else {
+			chainBalanceService.BalanceService = service.NewValuedEthereumBalanceService(balanceService, blockResolver, tokenDecimalsResolver, priceOracle, config.fiatCurrency, network.NativeSymbol)
+		}
+		chainBalanceServices = append(chainBalanceServices, chainBalanceService)
+	}
+	ethereumBalanceService = service.NewMultiChainBalanceService(chainBalanceServices)
 
-	ethereumBalanceService = service.NewEthereumBalanceService(balanceService)
+	nodeConfigDir := os.Getenv("PROXEUS_NODE_CONFIG_DIR")
+	if len(nodeConfigDir) == 0 {
+		nodeConfigDir = defaultNodeConfigDir
+	}
+	nodeConfigStore, err = service.NewNodeConfigStore(nodeConfigDir)
+	if err != nil {
+		log.Fatal("[taxreporter][run] node config store err: ", err.Error())
+	}
 
 	e := echo.New()
 	e.HideBanner = true
@@ -122,18 +192,403 @@ func main() {
 		g.Use(middleware.JWTWithConfig(conf))
 
 		g.POST("/next", next)
-		g.GET("/config", externalnode.Nop)
-		g.POST("/config", externalnode.Nop)
-		g.POST("/remove", externalnode.Nop)
-		g.POST("/close", externalnode.Nop)
+		g.GET("/config", getConfig)
+		g.POST("/config", setConfig)
+		g.POST("/remove", removeConfig)
+		g.POST("/close", closeConfig)
+	}
+	// The REST API is disabled without any API key
+	if apiKeys := splitList(os.Getenv("PROXEUS_API_KEYS")); len(apiKeys) != 0 {
+		jobsDir := os.Getenv("PROXEUS_JOBS_DIR")
+		if len(jobsDir) == 0 {
+			jobsDir = defaultJobsDir
+		}
+		jobTimeout := defaultJobTimeout
+		if timeout := os.Getenv("PROXEUS_JOB_TIMEOUT"); len(timeout) != 0 {
+			jobTimeout, err = time.ParseDuration(timeout)
+			if err != nil {
+				log.Fatal("[taxreporter][run] invalid PROXEUS_JOB_TIMEOUT: ", err.Error())
+			}
+		}
+		jobRunner, err = service.NewJobRunner(jobsDir, runAPIJob, jobTimeout, os.Getenv("PROXEUS_WEBHOOK_SECRET"), splitList(os.Getenv("PROXEUS_WEBHOOK_HOSTS")))
+		if err != nil {
+			log.Fatal("[taxreporter][run] job runner err: ", err.Error())
+		}
+		registerAPI(e, apiKeys)
 	}
 	externalnode.Register(proxeusUrl, serviceName, serviceUrl, jwtsecret, "Retrieves token balances of an address")
-	err := e.Start("0.0.0.0:" + servicePort)
+	err = e.Start("0.0.0.0:" + servicePort)
 	if err != nil {
 		log.Println("[taxreporter][run] Start err: ", err.Error())
 	}
 }
 
+// Merges the tokens of the token registry and the tokens of networks in the token list, either path can be empty
+func loadTokenRegistry(tokenRegistryPath, tokenListPath string, networks []blockchain.Network) (*service.TokenRegistry, error) {
+	tokenRegistry := &service.TokenRegistry{}
+	if len(tokenRegistryPath) != 0 {
+		var err error
+		tokenRegistry, err = service.LoadTokenRegistry(tokenRegistryPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(tokenListPath) != 0 {
+		for _, network := range networks {
+			tokenList, err := service.LoadTokenList(tokenListPath, network.ChainID, network.Name)
+			if err != nil {
+				return nil, err
+			}
+			err = tokenRegistry.Add(tokenList)
+			if err != nil {
+				return nil, fmt.Errorf("adding token list %s to the token registry: %v", tokenListPath, err)
+			}
+		}
+	}
+
+	return tokenRegistry, nil
+}
+
+// Default tokens of network. PROXEUS_<TOKEN>_ADDRESS overrides the contract of a token.
+func networkTokensMap(network blockchain.Network) map[string]string {
+	tokensMap := make(map[string]string)
+	for contract, symbol := range network.Tokens {
+		if address := os.Getenv("PROXEUS_" + symbol + "_ADDRESS"); len(address) != 0 {
+			contract = common.HexToAddress(address).Hex()
+		}
+		tokensMap[contract] = symbol
+	}
+	return tokensMap
+}
+
+// Reads the settings of the balance provider of network from the environment. Each setting can be set for one network
+// by suffixing its variable with the network name, e.g. PROXEUS_ETH_CLIENT_URL_POLYGON. When several networks are
+// configured, the client URL and the Multicall address have to be set per network, and the transfer index of each
+// network is kept in a subdirectory of PROXEUS_TRANSFER_INDEX_DIR.
+func readBalanceServiceConfig(network blockchain.Network, multiNetwork bool, tokenRegistry *service.TokenRegistry) (balanceServiceConfig, error) {
+	config := balanceServiceConfig{
+		network:       network,
+		tokenDecimals: make(map[string]uint8),
+	}
+
+	if tokenRegistry != nil {
+		config.tokensMap = tokenRegistry.TokensMap(network.Name)
+		if len(config.tokensMap) == 0 {
+			log.Printf("[taxreporter][run] no token of network %s in the token registry", network.Name)
+		}
+		config.tokenDecimals = tokenRegistry.Decimals(network.Name)
+		config.startBlock = tokenRegistry.StartBlock(network.Name)
+	} else {
+		config.tokensMap = networkTokensMap(network)
+	}
+
+	// Optional, tokens without configured decimals use the decimals of their contract
+	for _, symbol := range config.tokensMap {
+		decimals, _ := lookupNetworkEnv("PROXEUS_"+symbol+"_DECIMALS", network, true)
+		if len(decimals) == 0 {
+			continue
+		}
+		parsedDecimals, err := strconv.ParseUint(decimals, 10, 8)
+		if err != nil {
+			return config, fmt.Errorf("invalid PROXEUS_%s_DECIMALS: %v", symbol, err)
+		}
+		config.tokenDecimals[symbol] = uint8(parsedDecimals)
+	}
+
+	config.balanceProvider, _ = lookupNetworkEnv("BALANCE_PROVIDER", network, true)
+	if len(config.balanceProvider) == 0 {
+		config.balanceProvider = defaultBalanceProvider
+	}
+	config.ethClientUrl, _ = lookupNetworkEnv("PROXEUS_ETH_CLIENT_URL", network, !multiNetwork)
+	if len(config.ethClientUrl) == 0 {
+		config.ethClientUrl = network.DefaultEthClientUrl
+	}
+	config.infuraApiKey, _ = lookupNetworkEnv("PROXEUS_INFURA_API_KEY", network, true)
+	config.rpcBalanceMode, _ = lookupNetworkEnv("RPC_BALANCE_MODE", network, true)
+	if len(config.rpcBalanceMode) == 0 {
+		config.rpcBalanceMode = defaultRPCBalanceMode
+	}
+	transferIndexDir, ok := lookupNetworkEnv("PROXEUS_TRANSFER_INDEX_DIR", network, false)
+	if !ok {
+		transferIndexDir = os.Getenv("PROXEUS_TRANSFER_INDEX_DIR")
+		if multiNetwork && len(transferIndexDir) != 0 {
+			transferIndexDir = filepath.Join(transferIndexDir, network.Name)
+		}
+	}
+	config.transferIndexDir = transferIndexDir
+	// Set to an empty value to disable the Multicall contract of the network
+	config.multicallAddress, ok = lookupNetworkEnv("PROXEUS_MULTICALL_ADDRESS", network, !multiNetwork)
+	if !ok {
+		config.multicallAddress = network.MulticallAddress
+	}
+	config.confirmationDepth = uint64(defaultConfirmations)
+	if confirmations, _ := lookupNetworkEnv("PROXEUS_CONFIRMATION_DEPTH", network, true); len(confirmations) != 0 {
+		var err error
+		config.confirmationDepth, err = strconv.ParseUint(confirmations, 10, 64)
+		if err != nil {
+			return config, fmt.Errorf("invalid PROXEUS_CONFIRMATION_DEPTH: %v", err)
+		}
+	}
+	config.ethplorer.BaseURL = os.Getenv("PROXEUS_ETHPLORER_URL")
+	config.ethplorer.APIKey = os.Getenv("PROXEUS_ETHPLORER_API_KEY")
+	if timeout := os.Getenv("PROXEUS_ETHPLORER_TIMEOUT"); len(timeout) != 0 {
+		var err error
+		config.ethplorer.Timeout, err = time.ParseDuration(timeout)
+		if err != nil {
+			return config, fmt.Errorf("invalid PROXEUS_ETHPLORER_TIMEOUT: %v", err)
+		}
+	}
+
+	// Ethplorer returns the current prices along with the balances
+	config.priceOracle, _ = lookupNetworkEnv("PRICE_ORACLE", network, true)
+	if len(config.priceOracle) == 0 {
+		config.priceOracle = priceOracleNone
+		if config.balanceProvider == balanceProviderEthplorer {
+			config.priceOracle = priceOracleEthplorer
+		}
+	}
+	config.fiatCurrency = strings.ToUpper(os.Getenv("PROXEUS_FIAT_CURRENCY"))
+	if len(config.fiatCurrency) == 0 {
+		config.fiatCurrency = defaultFiatCurrency
+		if config.priceOracle == priceOracleEthplorer {
+			config.fiatCurrency = service.EthplorerCurrency
+		}
+	}
+	config.priceCSVPath, _ = lookupNetworkEnv("PROXEUS_PRICE_CSV", network, true)
+	config.httpPriceOracle.URL, _ = lookupNetworkEnv("PROXEUS_PRICE_URL", network, true)
+	config.httpPriceOracle.RatePath, _ = lookupNetworkEnv("PROXEUS_PRICE_RATE_PATH", network, true)
+	config.httpPriceOracle.TimestampPath, _ = lookupNetworkEnv("PROXEUS_PRICE_TIMESTAMP_PATH", network, true)
+	if timeout, _ := lookupNetworkEnv("PROXEUS_PRICE_TIMEOUT", network, true); len(timeout) != 0 {
+		var err error
+		config.httpPriceOracle.Timeout, err = time.ParseDuration(timeout)
+		if err != nil {
+			return config, fmt.Errorf("invalid PROXEUS_PRICE_TIMEOUT: %v", err)
+		}
+	}
+	// Feeds are specific to a network, e.g. "ETH/USD=0x5f4eC3Df9cbd43714FE2740f5E3616155c5b8419,CHF/USD=0x..."
+	feeds, _ := lookupNetworkEnv("PROXEUS_CHAINLINK_FEEDS", network, !multiNetwork)
+	config.chainlinkFeeds = make(map[string]common.Address)
+	for _, feed := range splitList(feeds) {
+		parts := strings.SplitN(feed, "=", 2)
+		if len(parts) != 2 || !strings.Contains(parts[0], "/") || !common.IsHexAddress(strings.TrimSpace(parts[1])) {
+			return config, fmt.Errorf("invalid PROXEUS_CHAINLINK_FEEDS entry %q, expected <token>/<currency>=<address>", feed)
+		}
+		config.chainlinkFeeds[strings.TrimSpace(parts[0])] = common.HexToAddress(strings.TrimSpace(parts[1]))
+	}
+
+	return config, nil
+}
+
+// Looks up the variable name suffixed with the network name, e.g. BALANCE_PROVIDER_POLYGON, then the variable name
+// itself if fallback is true
+func lookupNetworkEnv(name string, network blockchain.Network, fallback bool) (string, bool) {
+	value, ok := os.LookupEnv(name + "_" + strings.ToUpper(network.Name))
+	if ok || !fallback {
+		return value, ok
+	}
+	return os.LookupEnv(name)
+}
+
+// Settings of the balance provider, read from the environment
+type balanceServiceConfig struct {
+	network           blockchain.Network
+	balanceProvider   string
+	ethClientUrl      string
+	infuraApiKey      string
+	rpcBalanceMode    string
+	transferIndexDir  string
+	multicallAddress  string
+	confirmationDepth uint64
+	startBlock        uint64
+	tokensMap         map[string]string
+	tokenDecimals     map[string]uint8
+	ethplorer         service.EthplorerConfig
+	priceOracle       string
+	fiatCurrency      string
+	priceCSVPath      string
+	httpPriceOracle   service.HTTPPriceOracleConfig
+	chainlinkFeeds    map[string]common.Address
+}
+
+// Builds the EthBalanceService matching balanceProvider and the resolver of the decimals of its tokens. The block
+// resolver is nil if the provider doesn't support historical balances.
+// The rpc provider either calls "balanceOf" on the token contracts (rpcBalanceMode "call", historical balances need an
+// archive node) or replays their Transfer events (rpcBalanceMode "logs"). If transferIndexDir is set, the Transfer
+// events are indexed in it, setting it in another mode is an error. In "call" mode, the calls are aggregated through
+// the Multicall contract at multicallAddress if set. The rpc provider refuses to connect to a client of another
+// network.
+func newBalanceService(config balanceServiceConfig) (service.EthBalanceService, service.BlockResolver, service.TokenDecimalsResolver, error) {
+	switch config.balanceProvider {
+	case balanceProviderEthplorer:
+		// Ethplorer's API only covers mainnet
+		if config.network.ChainID != blockchain.Networks["mainnet"].ChainID {
+			return nil, nil, nil, fmt.Errorf("the %s balance provider doesn't support network %s", balanceProviderEthplorer, config.network.Name)
+		}
+		if len(config.transferIndexDir) != 0 {
+			return nil, nil, nil, fmt.Errorf("PROXEUS_TRANSFER_INDEX_DIR is set, but the %s balance provider doesn't use a transfer index", balanceProviderEthplorer)
+		}
+		ethplorerBalanceService := service.NewEthplorerBalanceService(config.ethplorer, config.tokensMap, config.tokenDecimals)
+		return ethplorerBalanceService, nil, ethplorerBalanceService, nil
+	case balanceProviderRPC:
+	default:
+		return nil, nil, nil, fmt.Errorf("unknown BALANCE_PROVIDER %q, expected %q or %q", config.balanceProvider, balanceProviderEthplorer, balanceProviderRPC)
+	}
+
+	if config.rpcBalanceMode != rpcBalanceModeCall && config.rpcBalanceMode != rpcBalanceModeLogs {
+		return nil, nil, nil, fmt.Errorf("unknown RPC_BALANCE_MODE %q, expected %q or %q", config.rpcBalanceMode, rpcBalanceModeCall, rpcBalanceModeLogs)
+	}
+	if len(config.transferIndexDir) != 0 && config.rpcBalanceMode != rpcBalanceModeLogs {
+		return nil, nil, nil, fmt.Errorf("PROXEUS_TRANSFER_INDEX_DIR is set, but the transfer index is only used in RPC_BALANCE_MODE %q", rpcBalanceModeLogs)
+	}
+	if len(config.multicallAddress) != 0 && !common.IsHexAddress(config.multicallAddress) {
+		return nil, nil, nil, fmt.Errorf("invalid PROXEUS_MULTICALL_ADDRESS %q", config.multicallAddress)
+	}
+
+	ethClient, err := dialEthClient(config)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	tokenDecimalsResolver, err := service.NewContractTokenDecimalsResolver(ethClient, config.tokensMap, config.tokenDecimals)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	var balanceService service.EthBalanceService
+	switch {
+	case config.rpcBalanceMode == rpcBalanceModeCall:
+		var multicall *common.Address
+		if len(config.multicallAddress) != 0 {
+			address := common.HexToAddress(config.multicallAddress)
+			multicall = &address
+		}
+		balanceService, err = service.NewCallBalanceService(ethClient, config.tokensMap, config.confirmationDepth, multicall)
+	case len(config.transferIndexDir) == 0:
+		balanceService, err = service.NewEthClientBalanceService(ethClient, config.tokensMap, config.confirmationDepth, config.startBlock)
+	default:
+		transferIndex, err := service.NewTransferIndex(config.transferIndexDir)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("opening transfer index %s: %v", config.transferIndexDir, err)
+		}
+		indexedBalanceService, err := service.NewIndexedEthClientBalanceService(ethClient, config.tokensMap, config.confirmationDepth, config.startBlock, transferIndex)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		return indexedBalanceService, service.NewBlockResolver(ethClient), tokenDecimalsResolver, nil
+	}
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return balanceService, service.NewBlockResolver(ethClient), tokenDecimalsResolver, nil
+}
+
+// Connects to the ethereum client of the network, refusing a client of another network
+func dialEthClient(config balanceServiceConfig) (service.EthereumClient, error) {
+	if isInfuraUrl(config.ethClientUrl) && len(config.infuraApiKey) == 0 {
+		return nil, errors.New("PROXEUS_INFURA_API_KEY is required to connect to " + config.ethClientUrl)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+	ethClient, err := service.DialEthereumClient(ctx, ethClientDialUrl(config))
+	if err != nil {
+		return nil, fmt.Errorf("connecting to ethereum client %s: %v", config.ethClientUrl, err)
+	}
+
+	// Dialing an http endpoint doesn't open a connection, make sure the client actually answers
+	_, err = ethClient.BlockHeaderByNumber(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("retrieving latest block from ethereum client %s: %v", config.ethClientUrl, err)
+	}
+	err = service.VerifyNetwork(ctx, ethClient, config.network)
+	if err != nil {
+		return nil, fmt.Errorf("ethereum client %s isn't connected to network %s: %v", config.ethClientUrl, config.network.Name, err)
+	}
+
+	return ethClient, nil
+}
+
+// URL to dial the ethereum client of the network with. The Infura API key is only appended to Infura endpoints: the
+// other clients of a multi-network setup have their own URL, e.g. https://bsc-dataseed.binance.org
+func ethClientDialUrl(config balanceServiceConfig) string {
+	if !isInfuraUrl(config.ethClientUrl) {
+		return config.ethClientUrl
+	}
+	return config.ethClientUrl + config.infuraApiKey
+}
+
+// True for the Infura endpoints expecting the API key at the end, e.g. https://mainnet.infura.io/v3/
+func isInfuraUrl(ethClientUrl string) bool {
+	parsedUrl, err := url.Parse(ethClientUrl)
+	if err != nil {
+		return false
+	}
+	return strings.HasSuffix(parsedUrl.Hostname(), "infura.io") && strings.HasSuffix(parsedUrl.Path, "/v3/")
+}
+
+// Builds the price oracle valuing the balances in config.fiatCurrency, nil without any. The ethplorer oracle is nil as
+// well: the ethplorer balance provider returns the current prices in US dollars along with the balances. The chainlink
+// oracle reads the feeds on the ethereum client of the network. The prices missing from the csv, http and chainlink
+// oracles are derived from their prices in crossRateCurrency.
+func newPriceOracle(config balanceServiceConfig) (service.PriceOracle, error) {
+	var (
+		priceOracle service.PriceOracle
+		err         error
+	)
+	switch config.priceOracle {
+	case priceOracleNone:
+		return nil, nil
+	case priceOracleEthplorer:
+		if config.balanceProvider != balanceProviderEthplorer {
+			return nil, fmt.Errorf("the %s price oracle requires the %s balance provider", priceOracleEthplorer, balanceProviderEthplorer)
+		}
+		if config.fiatCurrency != service.EthplorerCurrency {
+			return nil, fmt.Errorf("the %s price oracle only supports %s, not PROXEUS_FIAT_CURRENCY %s", priceOracleEthplorer, service.EthplorerCurrency, config.fiatCurrency)
+		}
+		return nil, nil
+	case priceOracleCSV:
+		if len(config.priceCSVPath) == 0 {
+			return nil, errors.New("PROXEUS_PRICE_CSV is required by the csv price oracle")
+		}
+		priceOracle, err = service.LoadCSVPriceOracle(config.priceCSVPath)
+	case priceOracleHTTP:
+		priceOracle, err = service.NewHTTPPriceOracle(config.httpPriceOracle)
+	case priceOracleChainlink:
+		if len(config.chainlinkFeeds) == 0 {
+			return nil, errors.New("PROXEUS_CHAINLINK_FEEDS is required by the chainlink price oracle")
+		}
+		ethClient, dialErr := dialEthClient(config)
+		if dialErr != nil {
+			return nil, dialErr
+		}
+		priceOracle, err = service.NewChainlinkPriceOracle(ethClient, config.chainlinkFeeds)
+	default:
+		return nil, fmt.Errorf("unknown PRICE_ORACLE %q, expected %q, %q, %q, %q or %q", config.priceOracle, priceOracleNone, priceOracleEthplorer, priceOracleCSV, priceOracleHTTP, priceOracleChainlink)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return service.NewCrossRatePriceOracle(priceOracle, crossRateCurrency), nil
+}
+
+// HTTP status reporting an error of the balance provider: the request can be fixed by the caller, retried later or only
+// fixed by the operator of the service
+func balancesErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, service.ErrEthplorerInvalidAddress):
+		return http.StatusBadRequest
+	case errors.Is(err, service.ErrEthplorerRateLimit):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, service.ErrEthplorerInvalidAPIKey):
+		return http.StatusBadGateway
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
 func next(c echo.Context) error {
 	body, err := ioutil.ReadAll(c.Request().Body)
 	if err != nil {
@@ -145,20 +600,133 @@ func next(c echo.Context) error {
 	if err != nil {
 		return err
 	}
-	ethAddress, ok := response["ethAddress"].(string)
-	if !ok {
+	config, err := nodeConfigStore.Get(c.Param("id"))
+	if err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+	balanceService, err := ethereumBalanceService.Select(config.Networks)
+	if err != nil {
+		return c.String(http.StatusInternalServerError, fmt.Sprintf("[taxreporter][next] invalid node config: %v", err))
+	}
+
+	// Each address field holds an address or an array of addresses
+	var ethAddresses []string
+	for _, path := range config.InputAddressPaths() {
+		ethAddressValue, _ := service.LookupPath(response, path)
+		switch value := ethAddressValue.(type) {
+		case string:
+			ethAddresses = append(ethAddresses, value)
+		case []interface{}:
+			for _, item := range value {
+				ethAddress, ok := item.(string)
+				if !ok {
+					return c.String(http.StatusInternalServerError, errCastingEthAddress.Error())
+				}
+				ethAddresses = append(ethAddresses, ethAddress)
+			}
+		default:
+			return c.String(http.StatusInternalServerError, errCastingEthAddress.Error())
+		}
+	}
+	ethAddresses, err = service.UniqueAddresses(ethAddresses)
+	if err != nil {
+		return c.String(http.StatusBadRequest, fmt.Sprintf("[taxreporter][next] %v", err))
+	}
+	if len(ethAddresses) == 0 {
 		return c.String(http.StatusInternalServerError, errCastingEthAddress.Error())
 	}
 
-	balanceResponse, err := ethereumBalanceService.GetBalances(c.Request().Context(), ethAddress)
+	// Optional ISO-8601 date with timezone (e.g. 2019-12-31T23:59:59+01:00). If set, balances are returned as of
+	// the last block mined at or before that moment. The date of the workflow data takes precedence over the one of
+	// the node config.
+	balanceDateValue, found := service.LookupPath(response, config.InputBalanceDatePath())
+	if !found || balanceDateValue == nil || balanceDateValue == "" {
+		balanceDateValue = config.BalanceDate
+	}
+	getBalances := balanceService.GetBalances
+	if balanceDateValue != "" {
+		balanceDateString, ok := balanceDateValue.(string)
+		if !ok {
+			return c.String(http.StatusInternalServerError, errCastingBalanceDate.Error())
+		}
+		balanceDate, err := time.Parse(time.RFC3339, balanceDateString)
+		if err != nil {
+			return c.String(http.StatusBadRequest, fmt.Sprintf("[taxreporter][next] invalid balanceDate: %v", err))
+		}
+		getBalances = func(ctx context.Context, ethAddress string) ([]service.ChainBalances, error) {
+			return balanceService.GetBalancesAtDate(ctx, ethAddress, balanceDate)
+		}
+	}
+	addressesBalances, totals, err := service.GetAddressesBalances(c.Request().Context(), ethAddresses, getBalances)
 	if err != nil {
-		return c.String(http.StatusInternalServerError, err.Error())
+		return c.String(balancesErrorStatus(err), fmt.Sprintf("[taxreporter][next] %v", err))
+	}
+
+	//fill the output with the balances: the amount in tokens and in base units (wei for ETH). With several addresses,
+	//the fields of each address are nested in an object named after the address and the top level fields hold the totals
+	output := make(map[string]interface{})
+	if len(addressesBalances) == 1 {
+		addChainsBalancesOutput(output, addressesBalances[0].Chains, config)
+	} else {
+		for _, addressBalances := range addressesBalances {
+			addressOutput := make(map[string]interface{})
+			addChainsBalancesOutput(addressOutput, addressBalances.Chains, config)
+			output[addressBalances.Address] = addressOutput
+		}
+		addChainsBalancesOutput(output, totals, config)
 	}
 
-	//fill response-map with balanceResponse result
-	for k, v := range balanceResponse {
-		response[k] = v.String()
+	err = service.WriteOutput(response, output, config.OutputObject, config.OutputPrefix, config.FailOnOverwrite)
+	if err != nil {
+		return c.String(http.StatusConflict, fmt.Sprintf("[taxreporter][next] %v", err))
 	}
 
 	return c.JSON(http.StatusOK, response)
 }
+
+// Adds the balances of each chain to output. With several networks, the fields of a chain are prefixed with its network
+// name, e.g. "polygon.USDC". The node config selects the tokens and can rename the fields.
+func addChainsBalancesOutput(output map[string]interface{}, chainsBalances []service.ChainBalances, config service.NodeConfig) {
+	// Currency -> value of all the balances of all the chains that have a price
+	fiatTotals := make(map[string]*service.TokenAmount)
+	for _, chainBalances := range chainsBalances {
+		chainPrefix := ""
+		if len(chainsBalances) > 1 {
+			chainPrefix = chainBalances.Network + "."
+		}
+		for k, v := range chainBalances.Balances {
+			if !config.IncludesToken(k) {
+				continue
+			}
+			field := config.OutputField(chainPrefix + k)
+			output[field] = v.Format(balanceScale, balanceRoundingMode)
+			output[field+"BaseUnits"] = v.Value.String()
+			output[field+"Decimals"] = v.Decimals
+
+			if config.FiatValues && v.Price != nil {
+				fiatValue := v.Price.Value(v)
+				fiatField := config.OutputField(chainPrefix + k + "_" + v.Price.Currency)
+				output[fiatField] = fiatValue.Format(fiatScale, balanceRoundingMode)
+				output[fiatField+"Rate"] = v.Price.FormatRate()
+				output[fiatField+"Timestamp"] = v.Price.Timestamp.Format(time.RFC3339)
+
+				if total, found := fiatTotals[v.Price.Currency]; found {
+					total.Value.Add(total.Value, fiatValue.Value)
+				} else {
+					fiatTotals[v.Price.Currency] = fiatValue
+				}
+			}
+		}
+		if chainBalances.Block != nil {
+			output[config.OutputField(chainPrefix+"balanceBlockNumber")] = chainBalances.Block.Number.String()
+			output[config.OutputField(chainPrefix+"balanceBlockHash")] = chainBalances.Block.Hash.Hex()
+			output[config.OutputField(chainPrefix+"balanceBlockConfirmed")] = chainBalances.Block.Confirmed
+		}
+		if len(chainBalances.Warnings) != 0 {
+			output[config.OutputField(chainPrefix+"balanceWarnings")] = chainBalances.Warnings
+		}
+	}
+	for currency, total := range fiatTotals {
+		output[config.OutputField("total_"+currency)] = total.Format(fiatScale, balanceRoundingMode)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/ProxeusApp/node-balance-retriever/blockchain"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadBalanceServiceConfig_EthClientUrl(t *testing.T) {
+	var requestedPaths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestedPaths = append(requestedPaths, r.URL.Path)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	defer setEnv("PROXEUS_INFURA_API_KEY", "key")()
+	defer setEnv("PROXEUS_INFURA_API_KEY_POLYGON", "polygon-key")()
+	defer setEnv("PROXEUS_ETH_CLIENT_URL_BSC", server.URL)()
+
+	t.Run("ShouldAppendKeyToInfuraUrl", func(t *testing.T) {
+		config, err := readBalanceServiceConfig(blockchain.Networks["mainnet"], true, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, "https://mainnet.infura.io/v3/", config.ethClientUrl)
+		assert.Equal(t, "https://mainnet.infura.io/v3/key", ethClientDialUrl(config))
+	})
+
+	t.Run("ShouldUseKeyOfNetwork", func(t *testing.T) {
+		config, err := readBalanceServiceConfig(blockchain.Networks["polygon"], true, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, "https://polygon-mainnet.infura.io/v3/polygon-key", ethClientDialUrl(config))
+	})
+
+	t.Run("ShouldNotAppendKeyToCustomUrl", func(t *testing.T) {
+		config, err := readBalanceServiceConfig(blockchain.Networks["bsc"], true, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, server.URL, ethClientDialUrl(config))
+
+		_, err = dialEthClient(config)
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), "retrieving latest block")
+		assert.Equal(t, []string{"/"}, requestedPaths)
+	})
+
+	t.Run("ShouldRequireKeyOfInfuraUrl", func(t *testing.T) {
+		defer setEnv("PROXEUS_INFURA_API_KEY_ARBITRUM", "")()
+
+		config, err := readBalanceServiceConfig(blockchain.Networks["arbitrum"], true, nil)
+		assert.Nil(t, err)
+		_, err = dialEthClient(config)
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), "PROXEUS_INFURA_API_KEY is required")
+	})
+}
+
+// Sets the environment variable and returns the function restoring its previous value
+func setEnv(name, value string) func() {
+	previous, ok := os.LookupEnv(name)
+	os.Setenv(name, value)
+	return func() {
+		if ok {
+			os.Setenv(name, previous)
+		} else {
+			os.Unsetenv(name)
+		}
+	}
+}
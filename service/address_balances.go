@@ -0,0 +1,114 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Balances of one of the addresses of a request, on every chain
+type AddressBalances struct {
+	Address string
+	Chains  []ChainBalances
+}
+
+// Amount of addresses of a request whose balances are retrieved at the same time
+const maxConcurrentAddresses = 4
+
+// Checksums (EIP-55) the addresses and removes the duplicates, keeping the order of their first occurrence
+func UniqueAddresses(addresses []string) ([]string, error) {
+	var unique []string
+	seen := make(map[string]bool)
+	for _, address := range addresses {
+		if !common.IsHexAddress(address) {
+			return nil, fmt.Errorf("invalid address %q", address)
+		}
+		checksumAddress := common.HexToAddress(address).Hex()
+		if seen[checksumAddress] {
+			continue
+		}
+		seen[checksumAddress] = true
+		unique = append(unique, checksumAddress)
+	}
+	return unique, nil
+}
+
+// Calls getBalances for every address, at most maxConcurrentAddresses at a time, and sums their balances per chain and
+// token. The first error cancels the calls still running for the other addresses and skips the remaining ones. The
+// totals have no block, as the balances of each address can be calculated at a different block.
+func GetAddressesBalances(ctx context.Context, addresses []string, getBalances func(ctx context.Context, ethAddress string) ([]ChainBalances, error)) ([]AddressBalances, []ChainBalances, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		results      = make([]AddressBalances, len(addresses))
+		wg           sync.WaitGroup
+		firstErr     error
+		firstErrLock sync.Mutex
+		running      = make(chan bool, maxConcurrentAddresses)
+	)
+	for i, address := range addresses {
+		select {
+		case running <- true:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func(i int, address string) {
+			defer wg.Done()
+			defer func() { <-running }()
+
+			chainsBalances, err := getBalances(ctx, address)
+			if err != nil {
+				firstErrLock.Lock()
+				if firstErr == nil {
+					firstErr = fmt.Errorf("address %s: %w", address, err)
+					cancel()
+				}
+				firstErrLock.Unlock()
+				return
+			}
+			results[i] = AddressBalances{Address: address, Chains: chainsBalances}
+		}(i, address)
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, nil, firstErr
+	}
+	if ctx.Err() != nil {
+		return nil, nil, ctx.Err()
+	}
+	return results, sumAddressesBalances(results), nil
+}
+
+func sumAddressesBalances(addressesBalances []AddressBalances) []ChainBalances {
+	var totals []ChainBalances
+	totalsIndexes := make(map[string]int) // network -> index in totals
+	for _, addressBalances := range addressesBalances {
+		for _, chainBalances := range addressBalances.Chains {
+			index, found := totalsIndexes[chainBalances.Network]
+			if !found {
+				index = len(totals)
+				totalsIndexes[chainBalances.Network] = index
+				totals = append(totals, ChainBalances{Network: chainBalances.Network, Balances: make(map[string]*TokenAmount)})
+			}
+
+			for symbol, amount := range chainBalances.Balances {
+				total, found := totals[index].Balances[symbol]
+				if !found {
+					total = &TokenAmount{Value: big.NewInt(0), Decimals: amount.Decimals, Price: amount.Price}
+					totals[index].Balances[symbol] = total
+				}
+				total.Value.Add(total.Value, amount.Value)
+			}
+		}
+	}
+	return totals
+}
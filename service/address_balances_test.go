@@ -0,0 +1,102 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUniqueAddresses(t *testing.T) {
+	addresses, err := UniqueAddresses([]string{
+		"0x043129ab3945d2bb75f3b5de21487343efbeffd2",
+		"0xA017ac5faC5941f95010b12570B812C974469c2C",
+		"0x043129AB3945D2BB75F3B5DE21487343EFBEFFD2",
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"0x043129ab3945D2bB75f3B5DE21487343EFBeffd2", "0xA017ac5faC5941f95010b12570B812C974469c2C"}, addresses)
+
+	_, err = UniqueAddresses([]string{"0x1234"})
+	assert.NotNil(t, err)
+}
+
+func TestGetAddressesBalances(t *testing.T) {
+	balances := map[string][]ChainBalances{
+		"0x1": {
+			{Network: "mainnet", Balances: map[string]*TokenAmount{"ETH": {Value: big.NewInt(1), Decimals: 18}, "XES": {Value: big.NewInt(5), Decimals: 18}}, Block: &BalancesBlock{Number: big.NewInt(600)}},
+			{Network: "polygon", Balances: map[string]*TokenAmount{"MATIC": {Value: big.NewInt(2), Decimals: 18}}},
+		},
+		"0x2": {
+			{Network: "mainnet", Balances: map[string]*TokenAmount{"ETH": {Value: big.NewInt(10), Decimals: 18}, "XES": {Value: big.NewInt(0), Decimals: 18}}, Block: &BalancesBlock{Number: big.NewInt(601)}},
+			{Network: "polygon", Balances: map[string]*TokenAmount{"MATIC": {Value: big.NewInt(20), Decimals: 18}}},
+		},
+	}
+	var lock sync.Mutex
+	getBalances := func(ctx context.Context, ethAddress string) ([]ChainBalances, error) {
+		lock.Lock()
+		defer lock.Unlock()
+		return balances[ethAddress], nil
+	}
+
+	t.Run("ShouldReturnBalancesOfEveryAddressAndTotals", func(t *testing.T) {
+		addressesBalances, totals, err := GetAddressesBalances(context.Background(), []string{"0x1", "0x2"}, getBalances)
+		assert.Nil(t, err)
+
+		assert.Len(t, addressesBalances, 2)
+		assert.Equal(t, "0x1", addressesBalances[0].Address)
+		assert.Equal(t, big.NewInt(600), addressesBalances[0].Chains[0].Block.Number)
+		assert.Equal(t, "0x2", addressesBalances[1].Address)
+
+		assert.Len(t, totals, 2)
+		assert.Equal(t, "mainnet", totals[0].Network)
+		assert.Equal(t, big.NewInt(11), totals[0].Balances["ETH"].Value)
+		assert.Equal(t, big.NewInt(5), totals[0].Balances["XES"].Value)
+		assert.Nil(t, totals[0].Block)
+		assert.Equal(t, "polygon", totals[1].Network)
+		assert.Equal(t, big.NewInt(22), totals[1].Balances["MATIC"].Value)
+
+		// Summing doesn't modify the balances of the addresses
+		assert.Equal(t, big.NewInt(1), addressesBalances[0].Chains[0].Balances["ETH"].Value)
+	})
+
+	t.Run("ShouldReturnErrorOfAnyAddress", func(t *testing.T) {
+		_, _, err := GetAddressesBalances(context.Background(), []string{"0x1", "0x2"}, func(ctx context.Context, ethAddress string) ([]ChainBalances, error) {
+			if ethAddress == "0x2" {
+				return nil, errors.New("eth error")
+			}
+			return getBalances(ctx, ethAddress)
+		})
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), "0x2")
+	})
+
+	t.Run("ShouldLimitConcurrentAddresses", func(t *testing.T) {
+		var addresses []string
+		for i := 0; i < maxConcurrentAddresses*3; i++ {
+			addresses = append(addresses, fmt.Sprintf("0x%d", i))
+		}
+
+		var running, maxRunning int32
+		addressesBalances, _, err := GetAddressesBalances(context.Background(), addresses, func(ctx context.Context, ethAddress string) ([]ChainBalances, error) {
+			current := atomic.AddInt32(&running, 1)
+			defer atomic.AddInt32(&running, -1)
+			for {
+				max := atomic.LoadInt32(&maxRunning)
+				if current <= max || atomic.CompareAndSwapInt32(&maxRunning, max, current) {
+					break
+				}
+			}
+			time.Sleep(time.Millisecond * 10)
+			return nil, nil
+		})
+		assert.Nil(t, err)
+		assert.Len(t, addressesBalances, len(addresses))
+		assert.Equal(t, int32(maxConcurrentAddresses), atomic.LoadInt32(&maxRunning))
+	})
+}
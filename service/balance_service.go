@@ -2,9 +2,68 @@ package service
 
 import (
 	"context"
+	"errors"
+	"math/big"
 	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+type (
+	EthBalanceService interface {
+		GetBalancesForAddress(ctx context.Context, address string) (*sync.Map, error)
+		// Same as GetBalancesForAddress, but balances are calculated as of blockNumber instead of the latest block.
+		// A nil blockNumber means the latest block.
+		GetBalancesForAddressAtBlock(ctx context.Context, address string, blockNumber *big.Int) (*BlockBalances, error)
+	}
+
+	// Balances returned by EthBalanceService.GetBalancesForAddressAtBlock, in the format of GetBalancesForAddress
+	BlockBalances struct {
+		Balances *sync.Map
+		// Nil if the service doesn't know at which block the balances were calculated
+		Block *BalancesBlock
+		// Current prices returned along with the balances by the services that know them (e.g. Ethplorer), by key of
+		// Balances
+		Prices map[string]*TokenPrice
+		// Problems that didn't prevent retrieving the balances, e.g. ignored tokens impersonating a configured token
+		Warnings []string
+	}
+
+	// Block at which balances were calculated
+	BalancesBlock struct {
+		Number *big.Int
+		Hash   common.Hash
+		// False if fewer than the confirmation depth blocks were mined on top of this block yet. The balances of a
+		// block that is not confirmed might change in case of a chain reorganisation.
+		Confirmed bool
+		// When the block was mined
+		Time time.Time
+	}
 )
 
-type EthBalanceService interface {
-	GetBalancesForAddress(ctx context.Context, address string) (*sync.Map, error)
+// Key of the native asset of the chain (ether on Ethereum) in the balances returned by EthBalanceService, whatever
+// its symbol on the chain
+const NativeAssetKey = "ETH"
+
+var errHistoricalBalancesNotSupported = errors.New("balances at a given block are not supported by this balance service")
+
+func newBalancesBlock(header *BlockHeader, headHeader *BlockHeader, confirmationDepth uint64) *BalancesBlock {
+	lastConfirmedBlock := lastConfirmedBlock(headHeader.Number, confirmationDepth)
+	return &BalancesBlock{
+		Number:    header.Number,
+		Hash:      header.Hash,
+		Confirmed: lastConfirmedBlock != nil && header.Number.Cmp(lastConfirmedBlock) <= 0,
+		Time:      time.Unix(int64(header.Time), 0).UTC(),
+	}
+}
+
+// Returns the last block with at least confirmationDepth blocks mined on top of it, nil if there's none yet
+func lastConfirmedBlock(headBlockNumber *big.Int, confirmationDepth uint64) *big.Int {
+	lastConfirmedBlock := new(big.Int).Sub(headBlockNumber, new(big.Int).SetUint64(confirmationDepth))
+	if lastConfirmedBlock.Sign() < 0 {
+		return nil
+	}
+
+	return lastConfirmedBlock
 }
@@ -0,0 +1,71 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+type (
+	BlockResolver interface {
+		// Returns the number of the last block mined at or before date
+		BlockNumberAt(ctx context.Context, date time.Time) (*big.Int, error)
+	}
+
+	ethClientBlockResolver struct {
+		ethClient EthereumClient
+	}
+)
+
+var errDateBeforeGenesis = errors.New("date is before the genesis block")
+
+func NewBlockResolver(ethClient EthereumClient) *ethClientBlockResolver {
+	return &ethClientBlockResolver{ethClient: ethClient}
+}
+
+// Binary search over block timestamps. Block timestamps are monotonically increasing, so it takes around
+// log2(latest block) calls to BlockHeaderByNumber to find the right block.
+func (me *ethClientBlockResolver) BlockNumberAt(ctx context.Context, date time.Time) (*big.Int, error) {
+	timestamp := date.Unix()
+
+	latestHeader, err := me.ethClient.BlockHeaderByNumber(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("retrieving latest block. error: %v", err)
+	}
+	if int64(latestHeader.Time) <= timestamp {
+		return latestHeader.Number, nil
+	}
+
+	genesisHeader, err := me.ethClient.BlockHeaderByNumber(ctx, big.NewInt(0))
+	if err != nil {
+		return nil, fmt.Errorf("retrieving genesis block. error: %v", err)
+	}
+	if int64(genesisHeader.Time) > timestamp {
+		return nil, errDateBeforeGenesis
+	}
+
+	// Invariant: block "low" was mined at or before date, block "high" after it
+	low := big.NewInt(0)
+	high := new(big.Int).Set(latestHeader.Number)
+	one := big.NewInt(1)
+
+	for new(big.Int).Sub(high, low).Cmp(one) > 0 {
+		middle := new(big.Int).Add(low, high)
+		middle.Rsh(middle, 1)
+
+		header, err := me.ethClient.BlockHeaderByNumber(ctx, middle)
+		if err != nil {
+			return nil, fmt.Errorf("block %d not found. error: %v", middle, err)
+		}
+
+		if int64(header.Time) <= timestamp {
+			low = middle
+		} else {
+			high = middle
+		}
+	}
+
+	return low, nil
+}
@@ -0,0 +1,49 @@
+package service
+
+import (
+	"context"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEthClientBlockResolver_BlockNumberAt(t *testing.T) {
+	ctx := context.Background()
+	blockResolver := NewBlockResolver(NewEthClientStub())
+
+	t.Run("exact block timestamp", func(t *testing.T) {
+		blockNumber, err := blockResolver.BlockNumberAt(ctx, time.Unix(stubGenesisTime+42*stubBlockTime, 0))
+
+		assert.Nil(t, err)
+		assert.Equal(t, big.NewInt(42), blockNumber)
+	})
+
+	t.Run("between two blocks", func(t *testing.T) {
+		blockNumber, err := blockResolver.BlockNumberAt(ctx, time.Unix(stubGenesisTime+42*stubBlockTime+14, 0))
+
+		assert.Nil(t, err)
+		assert.Equal(t, big.NewInt(42), blockNumber)
+	})
+
+	t.Run("genesis block", func(t *testing.T) {
+		blockNumber, err := blockResolver.BlockNumberAt(ctx, time.Unix(stubGenesisTime, 0))
+
+		assert.Nil(t, err)
+		assert.Equal(t, big.NewInt(0), blockNumber)
+	})
+
+	t.Run("after latest block", func(t *testing.T) {
+		blockNumber, err := blockResolver.BlockNumberAt(ctx, time.Unix(stubGenesisTime+1000*stubBlockTime, 0))
+
+		assert.Nil(t, err)
+		assert.Equal(t, big.NewInt(600), blockNumber)
+	})
+
+	t.Run("before genesis block", func(t *testing.T) {
+		_, err := blockResolver.BlockNumberAt(ctx, time.Unix(stubGenesisTime-1, 0))
+
+		assert.Equal(t, errDateBeforeGenesis, err)
+	})
+}
@@ -0,0 +1,238 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/big"
+	"strings"
+	"sync"
+
+	"github.com/ProxeusApp/node-balance-retriever/blockchain"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Retrieves ERC20 balances by calling "balanceOf" on every token contract, instead of replaying the "Transfer" events.
+// It's much faster and also works for tokens changing balances without emitting "Transfer" events (rebasing tokens,
+// fees on transfer,..). Balances at a past block require an archive node.
+// If a Multicall contract is available, all the calls are aggregated into a single one.
+type callBalanceService struct {
+	ethClient              EthereumClient
+	smartContractTokensMap map[string]string
+	erc20                  abi.ABI
+	multicall              abi.ABI
+	multicallAddress       *common.Address
+	confirmationDepth      uint64
+}
+
+// multicallAddress can be nil if there's no Multicall contract on the network
+func NewCallBalanceService(ethClient EthereumClient, contractTokensMap map[string]string, confirmationDepth uint64, multicallAddress *common.Address) (*callBalanceService, error) {
+	erc20, err := abi.JSON(strings.NewReader(blockchain.ERC20ABI))
+	if err != nil {
+		return nil, err
+	}
+
+	multicall, err := abi.JSON(strings.NewReader(blockchain.MulticallABI))
+	if err != nil {
+		return nil, err
+	}
+
+	return &callBalanceService{
+		ethClient:              ethClient,
+		smartContractTokensMap: contractTokensMap,
+		erc20:                  erc20,
+		multicall:              multicall,
+		multicallAddress:       multicallAddress,
+		confirmationDepth:      confirmationDepth,
+	}, nil
+}
+
+// Same format as ethClientBalanceService.GetBalancesForAddress
+func (me *callBalanceService) GetBalancesForAddress(ctx context.Context, address string) (*sync.Map, error) {
+	result, err := me.GetBalancesForAddressAtBlock(ctx, address, nil) // Last block
+	if err != nil {
+		return nil, err
+	}
+	return result.Balances, nil
+}
+
+// All the calls are made at toBlockNumber. If the block is replaced by a chain reorganisation in the meantime, the
+// balances are retrieved again.
+func (me *callBalanceService) GetBalancesForAddressAtBlock(ctx context.Context, address string, toBlockNumber *big.Int) (*BlockBalances, error) {
+	if !common.IsHexAddress(address) {
+		return nil, errInvalidEthAddress
+	}
+
+	for attempt := 1; ; attempt++ {
+		balances, block, err := me.getBalancesAtBlock(ctx, common.HexToAddress(address), toBlockNumber)
+		if err == nil {
+			return &BlockBalances{Balances: balances, Block: block}, nil
+		}
+		if err != errReorgDetected || attempt == maxReorgRetries {
+			return nil, err
+		}
+		log.Printf("Chain reorganisation detected while retrieving balances of %s, retrying", address)
+	}
+}
+
+func (me *callBalanceService) getBalancesAtBlock(ctx context.Context, address common.Address, toBlockNumber *big.Int) (*sync.Map, *BalancesBlock, error) {
+	headHeader, err := me.ethClient.BlockHeaderByNumber(ctx, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("retrieving latest block. error: %v", err)
+	}
+
+	blockHeader := headHeader
+	if toBlockNumber != nil {
+		blockHeader, err = me.ethClient.BlockHeaderByNumber(ctx, toBlockNumber)
+		if err != nil {
+			return nil, nil, fmt.Errorf("block %d not found. error: %v", toBlockNumber, err)
+		}
+	}
+
+	var (
+		balances *sync.Map
+		deployed bool
+	)
+	if me.multicallAddress != nil {
+		balances, deployed, err = me.multicallBalances(ctx, address, blockHeader.Number)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if !deployed {
+		balances, err = me.individualBalances(ctx, address, blockHeader.Number)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// Make sure all the calls were made on the same chain
+	if err = me.checkBlockHash(ctx, blockHeader); err != nil {
+		return nil, nil, err
+	}
+
+	return balances, newBalancesBlock(blockHeader, headHeader, me.confirmationDepth), nil
+}
+
+// One call per token, plus one to retrieve the ether balance
+func (me *callBalanceService) individualBalances(ctx context.Context, address common.Address, blockNumber *big.Int) (*sync.Map, error) {
+	balances := new(sync.Map)
+
+	ethBalance, err := me.ethClient.BalanceAt(ctx, address, blockNumber)
+	if err != nil {
+		return nil, fmt.Errorf("retrieving balance of %s. error: %v", address.Hex(), err)
+	}
+	balances.Store(NativeAssetKey, ethBalance)
+
+	for contract, tokenCode := range me.smartContractTokensMap {
+		contractAddress := common.HexToAddress(contract)
+		input, err := me.erc20.Pack("balanceOf", address)
+		if err != nil {
+			return nil, err
+		}
+
+		output, err := me.ethClient.CallContract(ctx, ethereum.CallMsg{To: &contractAddress, Data: input}, blockNumber)
+		if err != nil {
+			return nil, fmt.Errorf("retrieving %s balance of %s. error: %v", tokenCode, address.Hex(), err)
+		}
+
+		balance, err := me.unpackBalanceOf(contractAddress, output, blockNumber)
+		if err != nil {
+			return nil, err
+		}
+		balances.Store(tokenCode, balance)
+	}
+
+	return balances, nil
+}
+
+// Aggregates all the calls into a single Multicall "aggregate" call. Returns false if the Multicall contract isn't
+// deployed at blockNumber.
+func (me *callBalanceService) multicallBalances(ctx context.Context, address common.Address, blockNumber *big.Int) (*sync.Map, bool, error) {
+	getEthBalanceInput, err := me.multicall.Pack("getEthBalance", address)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// The first call retrieves the ether balance, the other ones the token balances in the order of tokenContracts
+	calls := []blockchain.MulticallCall{{Target: *me.multicallAddress, CallData: getEthBalanceInput}}
+	var tokenContracts []string
+	for contract := range me.smartContractTokensMap {
+		balanceOfInput, err := me.erc20.Pack("balanceOf", address)
+		if err != nil {
+			return nil, false, err
+		}
+		calls = append(calls, blockchain.MulticallCall{Target: common.HexToAddress(contract), CallData: balanceOfInput})
+		tokenContracts = append(tokenContracts, contract)
+	}
+
+	input, err := me.multicall.Pack("aggregate", calls)
+	if err != nil {
+		return nil, false, err
+	}
+
+	output, err := me.ethClient.CallContract(ctx, ethereum.CallMsg{To: me.multicallAddress, Data: input}, blockNumber)
+	if err != nil {
+		return nil, false, fmt.Errorf("retrieving balances of %s with multicall. error: %v", address.Hex(), err)
+	}
+	if len(output) == 0 {
+		log.Printf("No multicall contract at %s in block %d, using individual calls", me.multicallAddress.Hex(), blockNumber)
+		return nil, false, nil
+	}
+
+	var result blockchain.MulticallAggregateResult
+	err = me.multicall.Unpack(&result, "aggregate", output)
+	if err != nil {
+		return nil, false, fmt.Errorf("unpacking 'aggregate'. error: %v", err)
+	}
+	if len(result.ReturnData) != len(calls) {
+		return nil, false, fmt.Errorf("multicall returned %d results for %d calls", len(result.ReturnData), len(calls))
+	}
+
+	balances := new(sync.Map)
+
+	var ethBalance *big.Int
+	err = me.multicall.Unpack(&ethBalance, "getEthBalance", result.ReturnData[0])
+	if err != nil {
+		return nil, false, fmt.Errorf("unpacking 'getEthBalance'. error: %v", err)
+	}
+	balances.Store(NativeAssetKey, ethBalance)
+
+	for i, contract := range tokenContracts {
+		balance, err := me.unpackBalanceOf(common.HexToAddress(contract), result.ReturnData[i+1], blockNumber)
+		if err != nil {
+			return nil, false, err
+		}
+		balances.Store(me.smartContractTokensMap[contract], balance)
+	}
+
+	return balances, true, nil
+}
+
+func (me *callBalanceService) unpackBalanceOf(contract common.Address, output []byte, blockNumber *big.Int) (*big.Int, error) {
+	if len(output) == 0 {
+		return nil, fmt.Errorf("no contract at %s in block %d", contract.Hex(), blockNumber)
+	}
+
+	var balance *big.Int
+	err := me.erc20.Unpack(&balance, "balanceOf", output)
+	if err != nil {
+		return nil, fmt.Errorf("unpacking 'balanceOf' of %s. error: %v", contract.Hex(), err)
+	}
+
+	return balance, nil
+}
+
+func (me *callBalanceService) checkBlockHash(ctx context.Context, blockHeader *BlockHeader) error {
+	currentBlockHeader, err := me.ethClient.BlockHeaderByNumber(ctx, blockHeader.Number)
+	if err != nil {
+		return fmt.Errorf("block %d not found. error: %v", blockHeader.Number, err)
+	}
+	if currentBlockHeader.Hash != blockHeader.Hash {
+		return errReorgDetected
+	}
+
+	return nil
+}
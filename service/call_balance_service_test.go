@@ -0,0 +1,91 @@
+package service
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/ProxeusApp/node-balance-retriever/blockchain"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCallBalanceService_GetBalancesForAddressAtBlock(t *testing.T) {
+	ctx := context.Background()
+	ethClient := NewEthClientStub()
+
+	t.Run("balances", func(t *testing.T) {
+		balanceService, err := NewCallBalanceService(ethClient, map[string]string{
+			"0xA017ac5faC5941f95010b12570B812C974469c2C": "XES",
+			"0x9f8F72aA9304c8B593d555F12eF6589cC3A579A2": "MKR",
+		}, 12, nil)
+		assert.Nil(t, err)
+
+		result, err := balanceService.GetBalancesForAddressAtBlock(ctx, "0x043129ab3945D2bB75f3B5DE21487343EFBeffd2", big.NewInt(500))
+		assert.Nil(t, err)
+		balances, block := result.Balances, result.Block
+
+		ethBalance, _ := balances.Load("ETH")
+		xesBalance, _ := balances.Load("XES")
+		mkrBalance, _ := balances.Load("MKR")
+		assert.Equal(t, ethClient.EthBalance, ethBalance)
+		assert.Equal(t, ethClient.XESBalance, xesBalance)
+		assert.Equal(t, big.NewInt(0), mkrBalance)
+
+		assert.Equal(t, big.NewInt(500), block.Number)
+		assert.True(t, block.Confirmed)
+	})
+
+	t.Run("multicall", func(t *testing.T) {
+		multicallAddress := common.HexToAddress(blockchain.Multicall3Address)
+		balanceService, err := NewCallBalanceService(ethClient, map[string]string{
+			"0xA017ac5faC5941f95010b12570B812C974469c2C": "XES",
+			"0x9f8F72aA9304c8B593d555F12eF6589cC3A579A2": "MKR",
+		}, 12, &multicallAddress)
+		assert.Nil(t, err)
+
+		// Multicall deployed at block 500, not yet at block 300 (individual calls)
+		for _, blockNumber := range []int64{500, 300} {
+			result, err := balanceService.GetBalancesForAddressAtBlock(ctx, "0x043129ab3945D2bB75f3B5DE21487343EFBeffd2", big.NewInt(blockNumber))
+			assert.Nil(t, err)
+			balances, block := result.Balances, result.Block
+
+			ethBalance, _ := balances.Load("ETH")
+			xesBalance, _ := balances.Load("XES")
+			mkrBalance, _ := balances.Load("MKR")
+			assert.Equal(t, ethClient.EthBalance, ethBalance)
+			assert.Equal(t, ethClient.XESBalance, xesBalance)
+			assert.Equal(t, big.NewInt(0), mkrBalance)
+			assert.Equal(t, big.NewInt(blockNumber), block.Number)
+		}
+	})
+
+	t.Run("multicall no contract", func(t *testing.T) {
+		multicallAddress := common.HexToAddress(blockchain.Multicall3Address)
+		balanceService, err := NewCallBalanceService(ethClient, map[string]string{
+			"0x1217AC5fAC5941F95010B12570b812c974469c98": "CODE",
+		}, 12, &multicallAddress)
+		assert.Nil(t, err)
+
+		_, err = balanceService.GetBalancesForAddressAtBlock(ctx, "0x043129ab3945D2bB75f3B5DE21487343EFBeffd2", nil)
+		assert.NotNil(t, err)
+	})
+
+	t.Run("no contract", func(t *testing.T) {
+		balanceService, err := NewCallBalanceService(ethClient, map[string]string{
+			"0x1217AC5fAC5941F95010B12570b812c974469c98": "CODE",
+		}, 12, nil)
+		assert.Nil(t, err)
+
+		_, err = balanceService.GetBalancesForAddressAtBlock(ctx, "0x043129ab3945D2bB75f3B5DE21487343EFBeffd2", nil)
+		assert.NotNil(t, err)
+	})
+
+	t.Run("invalid address", func(t *testing.T) {
+		balanceService, err := NewCallBalanceService(ethClient, map[string]string{}, 12, nil)
+		assert.Nil(t, err)
+
+		_, err = balanceService.GetBalancesForAddressAtBlock(ctx, "0x1", nil)
+		assert.Equal(t, errInvalidEthAddress, err)
+	})
+}
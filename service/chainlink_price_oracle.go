@@ -0,0 +1,222 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ProxeusApp/node-balance-retriever/blockchain"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Reads the prices of Chainlink price feeds (https://data.chain.link). The latest price comes from "latestRoundData",
+// past prices from the last round updated at or before the date, found by a binary search over "getRoundData".
+// Chainlink has few feeds in other currencies than USD, combine it with NewCrossRatePriceOracle to derive them.
+type chainlinkPriceOracle struct {
+	ethClient EthereumClient
+	// token/currency (e.g. ETH/USD) -> address of the proxy of the feed
+	feeds        map[string]common.Address
+	aggregator   abi.ABI
+	decimals     map[common.Address]uint8
+	decimalsLock sync.RWMutex
+}
+
+// Bits of the round ID of a proxy holding the round ID of its aggregator, the higher bits hold the phase
+const chainlinkPhaseOffset = 64
+
+var errNoChainlinkRound = errors.New("no round")
+
+// feeds maps "<token>/<currency>" (e.g. "ETH/USD") to the address of the proxy of the feed
+func NewChainlinkPriceOracle(ethClient EthereumClient, feeds map[string]common.Address) (*chainlinkPriceOracle, error) {
+	aggregator, err := abi.JSON(strings.NewReader(blockchain.ChainlinkAggregatorABI))
+	if err != nil {
+		return nil, err
+	}
+
+	return &chainlinkPriceOracle{
+		ethClient:  ethClient,
+		feeds:      feeds,
+		aggregator: aggregator,
+		decimals:   make(map[common.Address]uint8),
+	}, nil
+}
+
+func (me *chainlinkPriceOracle) Price(ctx context.Context, symbol, currency string, date time.Time) (*TokenPrice, error) {
+	feed, ok := me.feeds[symbol+"/"+currency]
+	if !ok {
+		return nil, errUnknownTokenPrice
+	}
+
+	decimals, err := me.feedDecimals(ctx, feed)
+	if err != nil {
+		return nil, err
+	}
+
+	round, err := me.call(ctx, feed, "latestRoundData")
+	if err == errNoChainlinkRound {
+		return nil, fmt.Errorf("no contract at %s", feed.Hex())
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !date.IsZero() && round.UpdatedAt.Int64() > date.Unix() {
+		round, err = me.roundAt(ctx, feed, round.RoundId, date.Unix())
+		if err == errNoChainlinkRound {
+			return nil, errUnknownTokenPrice
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if round.Answer.Sign() <= 0 {
+		return nil, fmt.Errorf("invalid answer %s of Chainlink feed %s in round %s", round.Answer, feed.Hex(), round.RoundId)
+	}
+
+	return &TokenPrice{
+		Rate:      new(big.Rat).SetFrac(round.Answer, pow10(int(decimals))),
+		Currency:  currency,
+		Timestamp: time.Unix(round.UpdatedAt.Int64(), 0).UTC(),
+	}, nil
+}
+
+// Last round updated at or before timestamp, the latest round being after it. Starts with the phase of the latest
+// round, then goes back to the previous phases if the first round of the phase is after timestamp.
+func (me *chainlinkPriceOracle) roundAt(ctx context.Context, feed common.Address, latestRoundID *big.Int, timestamp int64) (*blockchain.ChainlinkRoundData, error) {
+	latestPhase := new(big.Int).Rsh(latestRoundID, chainlinkPhaseOffset).Uint64()
+	latestAggregatorRound := new(big.Int).SetUint64(math.MaxUint64)
+	latestAggregatorRound.And(latestAggregatorRound, latestRoundID)
+
+	for phase := latestPhase; phase > 0; phase-- {
+		// Rounds of a phase updated at or before timestamp come first: find the last one
+		before := func(aggregatorRound uint64) (*blockchain.ChainlinkRoundData, error) {
+			round, err := me.round(ctx, feed, phase, aggregatorRound)
+			if err != nil || round.UpdatedAt.Int64() > timestamp {
+				return nil, err
+			}
+			return round, nil
+		}
+
+		first, err := before(1)
+		if err != nil && err != errNoChainlinkRound {
+			return nil, err
+		}
+		if first == nil {
+			continue
+		}
+
+		// Invariant: round "low" (lowRound) is at or before timestamp, round "high" after it or missing
+		low, high, lowRound := uint64(1), uint64(0), first
+		if phase == latestPhase {
+			high = latestAggregatorRound.Uint64()
+		} else {
+			for high = 2; ; high *= 2 {
+				round, err := before(high)
+				if err != nil && err != errNoChainlinkRound {
+					return nil, err
+				}
+				if round == nil {
+					break
+				}
+				low, lowRound = high, round
+				if high > math.MaxUint64/2 {
+					return nil, fmt.Errorf("too many rounds in phase %d of Chainlink feed %s", phase, feed.Hex())
+				}
+			}
+		}
+
+		for high-low > 1 {
+			middle := low + (high-low)/2
+			round, err := before(middle)
+			if err != nil && err != errNoChainlinkRound {
+				return nil, err
+			}
+			if round != nil {
+				low, lowRound = middle, round
+			} else {
+				high = middle
+			}
+		}
+		return lowRound, nil
+	}
+
+	return nil, errNoChainlinkRound
+}
+
+// Returns errNoChainlinkRound if the round doesn't exist
+func (me *chainlinkPriceOracle) round(ctx context.Context, feed common.Address, phase uint64, aggregatorRound uint64) (*blockchain.ChainlinkRoundData, error) {
+	roundID := new(big.Int).Lsh(new(big.Int).SetUint64(phase), chainlinkPhaseOffset)
+	roundID.Or(roundID, new(big.Int).SetUint64(aggregatorRound))
+
+	round, err := me.call(ctx, feed, "getRoundData", roundID)
+	if err != nil {
+		return nil, err
+	}
+	// Older aggregators return an empty round instead of reverting
+	if round.UpdatedAt.Sign() == 0 {
+		return nil, errNoChainlinkRound
+	}
+	return round, nil
+}
+
+// Returns errNoChainlinkRound if the call reverted, e.g. because the requested round doesn't exist
+func (me *chainlinkPriceOracle) call(ctx context.Context, feed common.Address, method string, args ...interface{}) (*blockchain.ChainlinkRoundData, error) {
+	input, err := me.aggregator.Pack(method, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	// Depending on the client, a reverted call returns an error or no output
+	output, err := me.ethClient.CallContract(ctx, ethereum.CallMsg{To: &feed, Data: input}, nil)
+	if (err != nil && strings.Contains(err.Error(), "execution reverted")) || (err == nil && len(output) == 0) {
+		return nil, errNoChainlinkRound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("calling '%s' of Chainlink feed %s. error: %v", method, feed.Hex(), err)
+	}
+
+	round := &blockchain.ChainlinkRoundData{}
+	err = me.aggregator.Unpack(round, method, output)
+	if err != nil {
+		return nil, fmt.Errorf("unpacking '%s' of Chainlink feed %s. error: %v", method, feed.Hex(), err)
+	}
+	return round, nil
+}
+
+// Decimals of the answers of a feed never change, they're only retrieved once
+func (me *chainlinkPriceOracle) feedDecimals(ctx context.Context, feed common.Address) (uint8, error) {
+	me.decimalsLock.RLock()
+	decimals, ok := me.decimals[feed]
+	me.decimalsLock.RUnlock()
+	if ok {
+		return decimals, nil
+	}
+
+	input, err := me.aggregator.Pack("decimals")
+	if err != nil {
+		return 0, err
+	}
+	output, err := me.ethClient.CallContract(ctx, ethereum.CallMsg{To: &feed, Data: input}, nil)
+	if err != nil {
+		return 0, fmt.Errorf("retrieving decimals of Chainlink feed %s. error: %v", feed.Hex(), err)
+	}
+	if len(output) == 0 {
+		return 0, fmt.Errorf("no contract at %s", feed.Hex())
+	}
+	err = me.aggregator.Unpack(&decimals, "decimals", output)
+	if err != nil {
+		return 0, fmt.Errorf("unpacking 'decimals' of Chainlink feed %s. error: %v", feed.Hex(), err)
+	}
+
+	me.decimalsLock.Lock()
+	me.decimals[feed] = decimals
+	me.decimalsLock.Unlock()
+
+	return decimals, nil
+}
@@ -0,0 +1,53 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChainlinkPriceOracle_Price(t *testing.T) {
+	feed := common.HexToAddress("0x5f4eC3Df9cbd43714FE2740f5E3616155c5b8419")
+	priceOracle, err := NewChainlinkPriceOracle(newChainlinkFeedStub(), map[string]common.Address{"ETH/USD": feed})
+	assert.Nil(t, err)
+
+	roundTime := func(phase, aggregatorRound int64) time.Time {
+		return time.Unix(stubChainlinkStartTime+phase*stubChainlinkPhaseTime+aggregatorRound*stubChainlinkRoundTime, 0).UTC()
+	}
+
+	tests := []struct {
+		name         string
+		date         time.Time
+		expectedRate string // Empty if the price is unknown
+		expectedTime time.Time
+	}{
+		{"latest", time.Time{}, "2.03", roundTime(2, 3)},
+		{"after the latest round", roundTime(2, 3).Add(time.Hour), "2.03", roundTime(2, 3)},
+		{"between two rounds", roundTime(2, 2).Add(time.Second), "2.02", roundTime(2, 2)},
+		{"at a round", roundTime(2, 1), "2.01", roundTime(2, 1)},
+		{"previous phase", roundTime(2, 1).Add(-time.Second), "1.05", roundTime(1, 5)},
+		{"middle of the previous phase", roundTime(1, 3).Add(time.Second), "1.03", roundTime(1, 3)},
+		{"first round", roundTime(1, 1), "1.01", roundTime(1, 1)},
+		{"before the first round", roundTime(1, 1).Add(-time.Second), "", time.Time{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			price, err := priceOracle.Price(context.Background(), "ETH", "USD", test.date)
+			if len(test.expectedRate) == 0 {
+				assert.Equal(t, errUnknownTokenPrice, err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, test.expectedRate, price.FormatRate())
+			assert.Equal(t, "USD", price.Currency)
+			assert.Equal(t, test.expectedTime, price.Timestamp)
+		})
+	}
+
+	_, err = priceOracle.Price(context.Background(), "ETH", "CHF", time.Time{})
+	assert.Equal(t, errUnknownTokenPrice, err)
+}
@@ -0,0 +1,128 @@
+package service
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+type (
+	// Prices loaded from a CSV rate sheet with a header line, e.g. the year end rates of the tax authority:
+	//
+	//   token,currency,date,rate
+	//   ETH,CHF,2023-12-31,1909.42
+	//   XES,CHF,2023-12-31T23:59:59+01:00,0.0042
+	//
+	// Dates are either days (midnight UTC) or RFC 3339 timestamps. A rate applies from its date until the next rate
+	// of the same token and currency.
+	csvPriceOracle struct {
+		// token/currency -> prices sorted by timestamp
+		prices map[string][]*TokenPrice
+	}
+)
+
+const csvPriceDateLayout = "2006-01-02"
+
+var csvPriceOracleColumns = []string{"token", "currency", "date", "rate"}
+
+func LoadCSVPriceOracle(path string) (*csvPriceOracle, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	prices, err := readCSVPrices(file)
+	if err != nil {
+		return nil, fmt.Errorf("reading rate sheet %s. error: %v", path, err)
+	}
+	return &csvPriceOracle{prices: prices}, nil
+}
+
+func readCSVPrices(reader io.Reader) (map[string][]*TokenPrice, error) {
+	csvReader := csv.NewReader(reader)
+	csvReader.FieldsPerRecord = len(csvPriceOracleColumns)
+	csvReader.TrimLeadingSpace = true
+
+	header, err := csvReader.Read()
+	if err != nil {
+		return nil, err
+	}
+	for i, column := range csvPriceOracleColumns {
+		if !strings.EqualFold(strings.TrimSpace(header[i]), column) {
+			return nil, fmt.Errorf("expected the columns %s, got %s", strings.Join(csvPriceOracleColumns, ","), strings.Join(header, ","))
+		}
+	}
+
+	prices := make(map[string][]*TokenPrice)
+	for line := 2; ; line++ {
+		record, err := csvReader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		symbol, currency := strings.TrimSpace(record[0]), strings.ToUpper(strings.TrimSpace(record[1]))
+		if len(symbol) == 0 || len(currency) == 0 {
+			return nil, fmt.Errorf("line %d: missing token or currency", line)
+		}
+		timestamp, err := parseCSVPriceDate(strings.TrimSpace(record[2]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid date %q", line, record[2])
+		}
+		rate, ok := parseRate(strings.TrimSpace(record[3]))
+		if !ok || rate.Sign() < 0 {
+			return nil, fmt.Errorf("line %d: invalid rate %q", line, record[3])
+		}
+
+		key := symbol + "/" + currency
+		prices[key] = append(prices[key], &TokenPrice{Rate: rate, Currency: currency, Timestamp: timestamp})
+	}
+
+	for key, keyPrices := range prices {
+		sort.SliceStable(keyPrices, func(i, j int) bool {
+			return keyPrices[i].Timestamp.Before(keyPrices[j].Timestamp)
+		})
+		for i := 1; i < len(keyPrices); i++ {
+			if keyPrices[i].Timestamp.Equal(keyPrices[i-1].Timestamp) {
+				return nil, fmt.Errorf("two rates of %s at %s", key, keyPrices[i].Timestamp.Format(time.RFC3339))
+			}
+		}
+	}
+
+	return prices, nil
+}
+
+func parseCSVPriceDate(date string) (time.Time, error) {
+	if len(date) == len(csvPriceDateLayout) {
+		return time.Parse(csvPriceDateLayout, date)
+	}
+	timestamp, err := time.Parse(time.RFC3339, date)
+	return timestamp.UTC(), err
+}
+
+func (me *csvPriceOracle) Price(ctx context.Context, symbol, currency string, date time.Time) (*TokenPrice, error) {
+	prices := me.prices[symbol+"/"+strings.ToUpper(currency)]
+	if len(prices) == 0 {
+		return nil, errUnknownTokenPrice
+	}
+	if date.IsZero() {
+		return prices[len(prices)-1], nil
+	}
+
+	// Index of the first price after date, the previous one applies at date
+	next := sort.Search(len(prices), func(i int) bool {
+		return prices[i].Timestamp.After(date)
+	})
+	if next == 0 {
+		return nil, errUnknownTokenPrice
+	}
+	return prices[next-1], nil
+}
@@ -0,0 +1,78 @@
+package service
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCSVPriceOracle_Price(t *testing.T) {
+	dir, err := ioutil.TempDir("", "prices")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "prices.csv")
+	sheet := "token,currency,date,rate\n" +
+		"ETH,CHF,2023-12-31,1909.42\n" +
+		"ETH,chf,2022-12-31,1114.5\n" +
+		"XES,CHF,2023-12-31T23:00:00+01:00,4.2e-3\n"
+	assert.Nil(t, ioutil.WriteFile(path, []byte(sheet), 0600))
+
+	priceOracle, err := LoadCSVPriceOracle(path)
+	assert.Nil(t, err)
+
+	tests := []struct {
+		name         string
+		symbol       string
+		date         time.Time
+		expectedRate string // Empty if the price is unknown
+	}{
+		{"latest", "ETH", time.Time{}, "1909.42"},
+		{"year end", "ETH", time.Date(2023, 12, 31, 23, 59, 59, 0, time.FixedZone("CET", 3600)), "1909.42"},
+		{"previous rate", "ETH", time.Date(2023, 6, 30, 0, 0, 0, 0, time.UTC), "1114.5"},
+		{"before the first rate", "ETH", time.Date(2022, 12, 30, 0, 0, 0, 0, time.UTC), ""},
+		{"timestamp", "XES", time.Date(2023, 12, 31, 22, 0, 0, 0, time.UTC), "0.0042"},
+		{"before the timestamp", "XES", time.Date(2023, 12, 31, 21, 59, 59, 0, time.UTC), ""},
+		{"unknown token", "MKR", time.Time{}, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			price, err := priceOracle.Price(context.Background(), test.symbol, "CHF", test.date)
+			if len(test.expectedRate) == 0 {
+				assert.Equal(t, errUnknownTokenPrice, err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, test.expectedRate, price.FormatRate())
+			assert.Equal(t, "CHF", price.Currency)
+		})
+	}
+}
+
+func TestCSVPriceOracle_invalidSheet(t *testing.T) {
+	tests := []struct {
+		name  string
+		sheet string
+	}{
+		{"missing header", "ETH,CHF,2023-12-31,1909.42\n"},
+		{"missing column", "token,currency,date,rate\nETH,CHF,2023-12-31\n"},
+		{"invalid date", "token,currency,date,rate\nETH,CHF,31.12.2023,1909.42\n"},
+		{"invalid rate", "token,currency,date,rate\nETH,CHF,2023-12-31,1'909.42\n"},
+		{"negative rate", "token,currency,date,rate\nETH,CHF,2023-12-31,-1\n"},
+		{"duplicate rate", "token,currency,date,rate\nETH,CHF,2023-12-31,1\nETH,CHF,2023-12-31T00:00:00Z,2\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := readCSVPrices(strings.NewReader(test.sheet))
+			assert.NotNil(t, err)
+		})
+	}
+}
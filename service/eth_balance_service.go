@@ -8,6 +8,7 @@ import (
 	"math/big"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/ProxeusApp/node-balance-retriever/blockchain"
 	"github.com/ethereum/go-ethereum"
@@ -17,9 +18,10 @@ import (
 )
 
 type EthereumClient interface {
-	HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error)
+	BlockHeaderByNumber(ctx context.Context, number *big.Int) (*BlockHeader, error)
 	BalanceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (*big.Int, error)
 	FilterLogs(ctx context.Context, q ethereum.FilterQuery) ([]types.Log, error)
+	CallContract(ctx context.Context, msg ethereum.CallMsg, blockNumber *big.Int) ([]byte, error)
 }
 
 type ethClientBalanceService struct {
@@ -28,20 +30,46 @@ type ethClientBalanceService struct {
 	erc20                  abi.ABI
 	workersPoolSize        int
 	balanceLock            sync.Mutex
+	transferIndex          *transferIndex
+	transferIndexLock      sync.Mutex
+	confirmationDepth      uint64
+	// No "Transfer" event is scanned before this block
+	startBlock uint64
 }
 
 type job struct {
 	ctx          context.Context
-	address      string
 	startBlock   *big.Int
 	endBlock     *big.Int
-	balancesMap  *sync.Map
-	jobsDoneChan chan bool
+	handleLogs   func(logs []types.Log) error
+	jobsDoneChan chan scannedChunk
 }
 
-var errInvalidEthAddress = errors.New("invalid address")
+// Sent by a worker once the logs of a chunk are handled
+type scannedChunk struct {
+	startBlock *big.Int
+	endBlock   *big.Int
+	logs       int
+}
+
+const (
+	// Amount of blocks scanned before the transfer index is saved
+	transferIndexBatchSize = 100000
+	// Amount of blocks of which the "Transfer" events are retrieved at once
+	scanChunkSize = 600
+	// Amount of times balances are calculated again when a chain reorganisation happened while calculating them
+	maxReorgRetries = 3
+)
 
-func NewEthClientBalanceService(ethClient EthereumClient, contractTokensMap map[string]string) (*ethClientBalanceService, error) {
+var (
+	errInvalidEthAddress = errors.New("invalid address")
+	errReorgDetected     = errors.New("chain reorganisation detected")
+)
+
+// Blocks with fewer than confirmationDepth blocks mined on top of them are considered provisional. They're never
+// added to the transfer index, and balances calculated at such a block are reported as not confirmed.
+// startBlock is the earliest block a token contract was deployed in, 0 if unknown.
+func NewEthClientBalanceService(ethClient EthereumClient, contractTokensMap map[string]string, confirmationDepth uint64, startBlock uint64) (*ethClientBalanceService, error) {
 	erc20, err := abi.JSON(strings.NewReader(blockchain.ERC20ABI))
 	if err != nil {
 		return nil, err
@@ -52,9 +80,23 @@ func NewEthClientBalanceService(ethClient EthereumClient, contractTokensMap map[
 		smartContractTokensMap: contractTokensMap,
 		workersPoolSize:        8,
 		erc20:                  erc20,
+		confirmationDepth:      confirmationDepth,
+		startBlock:             startBlock,
 	}, nil
 }
 
+// Same as NewEthClientBalanceService, but ERC20 balances are retrieved from transferIndex instead of scanning the
+// whole blockchain on every request. Only the blocks mined since the last request are scanned.
+func NewIndexedEthClientBalanceService(ethClient EthereumClient, contractTokensMap map[string]string, confirmationDepth uint64, startBlock uint64, transferIndex *transferIndex) (*ethClientBalanceService, error) {
+	balanceService, err := NewEthClientBalanceService(ethClient, contractTokensMap, confirmationDepth, startBlock)
+	if err != nil {
+		return nil, err
+	}
+
+	balanceService.transferIndex = transferIndex
+	return balanceService, nil
+}
+
 // Retrieves balances for an Ethereum address. Given an address in hexadecimal format, will return a *sync.Map of string->*big.Int, containing listed ERC20 tokens from "smartContractTokensMap" + "ETH".
 // Balances are measured in wei and every ERC20 token might have a different "decimals" amount. Please refer to the token to get that number
 // For ex. if "smartContractTokensMap" contains ETH, XES and MKR, calling this function will return you a map in the following format:
@@ -66,96 +108,409 @@ func NewEthClientBalanceService(ethClient EthereumClient, contractTokensMap map[
 // }
 //
 func (me *ethClientBalanceService) GetBalancesForAddress(ctx context.Context, address string) (*sync.Map, error) {
+	result, err := me.GetBalancesForAddressAtBlock(ctx, address, nil) // Last block
+	if err != nil {
+		return nil, err
+	}
+	return result.Balances, nil
+}
+
+// Retrieves balances for an Ethereum address as of toBlockNumber. Both the ETH balance and the ERC20 Transfer events
+// are taken up to (and including) that block, so all the totals are consistent at that height.
+// A nil toBlockNumber means the last block.
+// If the block is replaced by a chain reorganisation while the balances are calculated, they're calculated again.
+func (me *ethClientBalanceService) GetBalancesForAddressAtBlock(ctx context.Context, address string, toBlockNumber *big.Int) (*BlockBalances, error) {
 	if !common.IsHexAddress(address) {
 		return nil, errInvalidEthAddress
 	}
 
 	address = common.HexToAddress(address).String() //convert to EIP-55
 
-	var toBlockNumber *big.Int = nil // Last block
+	for attempt := 1; ; attempt++ {
+		balances, block, err := me.getBalancesAtBlock(ctx, address, toBlockNumber)
+		if err == nil {
+			return &BlockBalances{Balances: balances, Block: block}, nil
+		}
+		if err != errReorgDetected || attempt == maxReorgRetries {
+			return nil, err
+		}
+		log.Printf("Chain reorganisation detected while retrieving balances of %s, retrying", address)
+	}
+}
 
-	// Make sure block number exists, and retrieve it (in case of nil, will return the last block)
-	blockHeader, err := me.ethClient.HeaderByNumber(ctx, toBlockNumber)
+func (me *ethClientBalanceService) getBalancesAtBlock(ctx context.Context, address string, toBlockNumber *big.Int) (*sync.Map, *BalancesBlock, error) {
+	headHeader, err := me.ethClient.BlockHeaderByNumber(ctx, nil)
 	if err != nil {
-		return nil, fmt.Errorf("block %d not found. error: %v", toBlockNumber, err)
+		return nil, nil, fmt.Errorf("retrieving latest block. error: %v", err)
+	}
+
+	// Make sure block number exists, and retrieve it
+	blockHeader := headHeader
+	if toBlockNumber != nil {
+		blockHeader, err = me.ethClient.BlockHeaderByNumber(ctx, toBlockNumber)
+		if err != nil {
+			return nil, nil, fmt.Errorf("block %d not found. error: %v", toBlockNumber, err)
+		}
 	}
 
 	// Retrieve ether's balance
 	ethBalance, err := me.ethClient.BalanceAt(ctx, common.HexToAddress(address), blockHeader.Number)
 	if err != nil {
-		return nil, fmt.Errorf("retrieving balance of %s. error: %v", address, err)
+		return nil, nil, fmt.Errorf("retrieving balance of %s. error: %v", address, err)
 	}
 
 	// Retrieve all ERC20 token balances (listed in smartContractTokensMap)
-	balances, err := me.extractERC20Balances(ctx, blockHeader.Number, address)
+	var balances *sync.Map
+	if me.transferIndex != nil {
+		balances, err = me.indexedERC20Balances(ctx, blockHeader.Number, headHeader.Number, address)
+	} else {
+		balances, err = me.extractERC20Balances(ctx, new(big.Int).SetUint64(me.startBlock), blockHeader.Number, address)
+	}
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	balances.Store("ETH", ethBalance)
+	// Make sure the block is still part of the chain, otherwise the balances are a mix of two different chains
+	currentBlockHeader, err := me.ethClient.BlockHeaderByNumber(ctx, blockHeader.Number)
+	if err != nil {
+		return nil, nil, fmt.Errorf("block %d not found. error: %v", blockHeader.Number, err)
+	}
+	if currentBlockHeader.Hash != blockHeader.Hash {
+		return nil, nil, errReorgDetected
+	}
+
+	balances.Store(NativeAssetKey, ethBalance)
 
 	log.Println("Total balances", balances)
 
+	return balances, newBalancesBlock(blockHeader, headHeader, me.confirmationDepth), nil
+}
+
+// Sums the "Transfer" events from and to address between fromBlockNumber and toBlockNumber
+func (me *ethClientBalanceService) extractERC20Balances(ctx context.Context, fromBlockNumber *big.Int, toBlockNumber *big.Int, address string) (*sync.Map, error) {
+	var balancesMap sync.Map
+
+	err := me.scanTransferLogs(ctx, fromBlockNumber, toBlockNumber, func(logs []types.Log) error {
+		for _, eventLog := range logs {
+			tokenCode, found := me.smartContractTokensMap[eventLog.Address.Hex()]
+			if !found {
+				log.Printf("Token %s not found, we don't have a mapping to smart contract. address %s", tokenCode, eventLog.Address.Hex())
+				continue
+			}
+
+			// The log was part of a block that has been replaced by a chain reorganisation
+			if eventLog.Removed {
+				continue
+			}
+
+			transferEvent, err := me.parseTransferEventFromLog(eventLog)
+			if err != nil {
+				return err
+			}
+
+			me.balanceLock.Lock()
+
+			balanceInterface, _ := balancesMap.LoadOrStore(tokenCode, big.NewInt(0))
+			addressBalance := balanceInterface.(*big.Int)
+
+			if transferEvent.IsReceiver(address) {
+				newBalance := big.NewInt(0).Add(addressBalance, transferEvent.Value)
+				log.Printf("Detected an incoming transfer of %d %s (txHash %s). New balance: %d", transferEvent.Value, tokenCode, eventLog.TxHash.Hex(), newBalance)
+				balancesMap.Store(tokenCode, newBalance)
+			}
+
+			if transferEvent.IsSender(address) {
+				newBalance := big.NewInt(0).Sub(addressBalance, transferEvent.Value)
+				log.Printf("Detected an outgoing transfer of %d %s (txHash %s). New balance: %d", transferEvent.Value, tokenCode, eventLog.TxHash.Hex(), newBalance)
+				balancesMap.Store(tokenCode, newBalance)
+			}
+
+			me.balanceLock.Unlock()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &balancesMap, nil
+}
+
+// Retrieves the ERC20 balances from the transfer index. The index is first brought up to date with the confirmed
+// blocks mined since the last request. Provisional blocks are not indexed, they're scanned on every request.
+func (me *ethClientBalanceService) indexedERC20Balances(ctx context.Context, toBlockNumber *big.Int, headBlockNumber *big.Int, address string) (*sync.Map, error) {
+	indexedToBlockNumber := lastConfirmedBlock(headBlockNumber, me.confirmationDepth)
+	if indexedToBlockNumber != nil && indexedToBlockNumber.Cmp(toBlockNumber) > 0 {
+		indexedToBlockNumber = toBlockNumber
+	}
+
+	balances := new(sync.Map)
+	if indexedToBlockNumber != nil {
+		err := me.updateTransferIndex(ctx, indexedToBlockNumber)
+		if err != nil {
+			return nil, err
+		}
+
+		for contract, tokenCode := range me.smartContractTokensMap {
+			balance, err := me.transferIndex.balanceAt(contract, address, indexedToBlockNumber.Uint64())
+			if err != nil {
+				return nil, err
+			}
+			balances.Store(tokenCode, balance)
+		}
+	}
+
+	if indexedToBlockNumber != nil && indexedToBlockNumber.Cmp(toBlockNumber) == 0 {
+		return balances, nil
+	}
+
+	provisionalFromBlockNumber := new(big.Int).SetUint64(me.startBlock)
+	if indexedToBlockNumber != nil && indexedToBlockNumber.Cmp(provisionalFromBlockNumber) >= 0 {
+		provisionalFromBlockNumber.Add(indexedToBlockNumber, big.NewInt(1))
+	}
+	provisionalBalances, err := me.extractERC20Balances(ctx, provisionalFromBlockNumber, toBlockNumber, address)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, tokenCode := range me.smartContractTokensMap {
+		balance := big.NewInt(0)
+		if indexedBalance, found := balances.Load(tokenCode); found {
+			balance.Add(balance, indexedBalance.(*big.Int))
+		}
+		if provisionalBalance, found := provisionalBalances.Load(tokenCode); found {
+			balance.Add(balance, provisionalBalance.(*big.Int))
+		}
+		balances.Store(tokenCode, balance)
+	}
+
 	return balances, nil
 }
 
-func (me *ethClientBalanceService) extractERC20Balances(ctx context.Context, toBlockNumber *big.Int, address string) (*sync.Map, error) {
-	var (
-		balancesMap  sync.Map
-		jobsChan     = make(chan job, 1000)
-		jobsDoneChan = make(chan bool, 100)
-		errChan      = make(chan error, 1)
-	)
-	defer close(jobsChan)
-	defer close(jobsDoneChan)
-	defer close(errChan)
+// Makes sure the last indexed blocks are still part of the chain. If they're not, the index is rolled back to the
+// last block that still is, so the replaced blocks are scanned again.
+func (me *ethClientBalanceService) checkTransferIndexForReorg(ctx context.Context) error {
+	checkpoints := me.transferIndex.checkpoints()
+	for i, checkpoint := range checkpoints {
+		header, err := me.ethClient.BlockHeaderByNumber(ctx, new(big.Int).SetUint64(checkpoint.blockNumber))
+		// The chain replacing the indexed blocks can be shorter
+		if err == ethereum.NotFound {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("block %d not found. error: %v", checkpoint.blockNumber, err)
+		}
+		if header.Hash.Hex() != checkpoint.blockHash {
+			continue
+		}
+
+		if i > 0 {
+			log.Printf("Chain reorganisation detected, rolling back transfer index to block %d", checkpoint.blockNumber)
+			return me.transferIndex.rollback(checkpoint.blockNumber)
+		}
+		return nil
+	}
+
+	if len(checkpoints) > 0 {
+		log.Printf("Chain reorganisation detected before block %d, resetting transfer index", checkpoints[len(checkpoints)-1].blockNumber)
+		return me.transferIndex.reset()
+	}
 
+	return nil
+}
+
+// Fetches the "Transfer" events of the blocks that are not indexed yet, up to toBlockNumber. The index is saved
+// every transferIndexBatchSize blocks, so an interrupted scan resumes where it stopped.
+func (me *ethClientBalanceService) updateTransferIndex(ctx context.Context, toBlockNumber *big.Int) error {
+	me.transferIndexLock.Lock()
+	defer me.transferIndexLock.Unlock()
+
+	err := me.checkTransferIndexForReorg(ctx)
+	if err != nil {
+		return err
+	}
+
+	contracts := make([]string, 0, len(me.smartContractTokensMap))
+	for contract := range me.smartContractTokensMap {
+		contracts = append(contracts, contract)
+	}
+	if len(contracts) == 0 {
+		return nil
+	}
+
+	nextBlockToIndex := func() uint64 {
+		fromBlock := me.transferIndex.nextBlockToIndex(contracts)
+		if fromBlock < me.startBlock {
+			return me.startBlock
+		}
+		return fromBlock
+	}
+
+	toBlock := toBlockNumber.Uint64()
+	for fromBlock := nextBlockToIndex(); fromBlock <= toBlock; fromBlock = nextBlockToIndex() {
+		batchToBlock := fromBlock + transferIndexBatchSize - 1
+		if batchToBlock > toBlock {
+			batchToBlock = toBlock
+		}
+
+		// Keep the hash of the last block of the batch, to detect a chain reorganisation on the next update
+		batchToBlockHeader, err := me.ethClient.BlockHeaderByNumber(ctx, new(big.Int).SetUint64(batchToBlock))
+		if err != nil {
+			return fmt.Errorf("block %d not found. error: %v", batchToBlock, err)
+		}
+
+		var (
+			transfers     []indexedTransfer
+			transfersLock sync.Mutex
+		)
+		err = me.scanTransferLogs(ctx, new(big.Int).SetUint64(fromBlock), new(big.Int).SetUint64(batchToBlock), func(logs []types.Log) error {
+			for _, eventLog := range logs {
+				if _, found := me.smartContractTokensMap[eventLog.Address.Hex()]; !found || eventLog.Removed {
+					continue
+				}
+
+				transferEvent, err := me.parseTransferEventFromLog(eventLog)
+				if err != nil {
+					return err
+				}
+
+				transfersLock.Lock()
+				transfers = append(transfers, indexedTransfer{
+					Contract:    eventLog.Address.Hex(),
+					BlockNumber: eventLog.BlockNumber,
+					TxHash:      eventLog.TxHash.Hex(),
+					LogIndex:    eventLog.Index,
+					From:        transferEvent.From.Hex(),
+					To:          transferEvent.To.Hex(),
+					Value:       transferEvent.Value,
+				})
+				transfersLock.Unlock()
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		err = me.transferIndex.add(transfers, contracts, batchToBlock, batchToBlockHeader.Hash.Hex())
+		if err != nil {
+			return fmt.Errorf("saving transfer index. error: %v", err)
+		}
+		log.Printf("Transfer index updated up to block %d", batchToBlock)
+	}
+
+	return nil
+}
+
+// Retrieves all the "Transfer" events of the tracked contracts between fromBlockNumber and toBlockNumber, using a pool
+// of workers. Every chunk of logs is passed to handleLogs, which may be called concurrently. The progress is reported
+// after every chunk if ctx reports scan progress. Like an errgroup, the first error of a worker cancels the others and
+// is returned, cancelling ctx stops the workers and the scan returns ctx.Err(). handleLogs is never called once the
+// scan returned.
+func (me *ethClientBalanceService) scanTransferLogs(ctx context.Context, fromBlockNumber *big.Int, toBlockNumber *big.Int, handleLogs func(logs []types.Log) error) error {
 	// Split into block chunks as we don't want (can't) to process the whole blockchain at once
-	startBlocks, endBlocks, err := me.getBlockChunks(big.NewInt(int64(0)), toBlockNumber, 600)
+	startBlocks, endBlocks, err := me.getBlockChunks(fromBlockNumber, toBlockNumber, scanChunkSize)
 	if err != nil {
-		return nil, err
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		// Only closed by the producer of jobs, once it stopped sending
+		jobsChan = make(chan job, 1000)
+		// Only closed once the producer and all the workers stopped
+		jobsDoneChan = make(chan scannedChunk, 100)
+		running      sync.WaitGroup
+		scanErr      error
+		scanErrOnce  sync.Once
+	)
+	fail := func(err error) {
+		scanErrOnce.Do(func() {
+			scanErr = err
+			cancel()
+		})
 	}
 
+	progress := ScanProgress{TotalChunks: len(startBlocks)}
+	if toBlockNumber.Cmp(fromBlockNumber) >= 0 {
+		progress.TotalBlocks = new(big.Int).Sub(toBlockNumber, fromBlockNumber).Uint64() + 1
+	}
+	reportProgress := newScanProgressFunc(ctx)
+	reportProgress(progress)
+	startTime := time.Now()
+
 	// Create a pool of workers
+	running.Add(me.workersPoolSize + 1)
 	for workerId := 1; workerId <= me.workersPoolSize; workerId++ {
-		go me.worker(workerId, jobsChan, errChan)
+		go func(workerId int) {
+			defer running.Done()
+			me.worker(workerId, jobsChan, fail)
+		}(workerId)
 	}
 
 	go func() {
+		defer running.Done()
+		// Workers stop once all the jobs are received
+		defer close(jobsChan)
 		for i, startBlock := range startBlocks {
-			endBlock := endBlocks[i]
-
-			jobsChan <- job{
+			select {
+			case jobsChan <- job{
 				ctx:          ctx,
-				address:      address,
 				startBlock:   startBlock,
-				endBlock:     endBlock,
-				balancesMap:  &balancesMap,
+				endBlock:     endBlocks[i],
+				handleLogs:   handleLogs,
 				jobsDoneChan: jobsDoneChan,
+			}:
+			case <-ctx.Done():
+				return
 			}
 		}
 		log.Println("All jobs sent to chan")
 	}()
 
-	// Wait until all jobs are processed. Each one could return an error
-	for r := 0; r < len(startBlocks); r++ {
-		select {
-		case err := <-errChan:
-			log.Printf("An error occurred %v", err)
-			return nil, err
-		case <-jobsDoneChan:
-		}
+	go func() {
+		running.Wait()
+		close(jobsDoneChan)
+	}()
+
+	// Wait until all the jobs are processed or the scan is stopped
+	for chunk := range jobsDoneChan {
+		progress.ScannedChunks++
+		progress.ScannedBlocks += new(big.Int).Sub(chunk.endBlock, chunk.startBlock).Uint64() + 1
+		progress.FromBlock = chunk.startBlock.Uint64()
+		progress.ToBlock = chunk.endBlock.Uint64()
+		progress.ProcessedLogs += uint64(chunk.logs)
+		progress.ETA = estimateScanTime(time.Since(startTime), progress.ScannedBlocks, progress.TotalBlocks-progress.ScannedBlocks)
+		reportProgress(progress)
 	}
 
-	return &balancesMap, nil
+	// The workers stopped, scanErr can't change anymore
+	if scanErr != nil {
+		log.Printf("An error occurred %v", scanErr)
+		return scanErr
+	}
+	if progress.ScannedChunks < len(startBlocks) {
+		return ctx.Err()
+	}
+	log.Printf("[taxreporter][scan] scanned %d blocks and %d logs in %s", progress.ScannedBlocks, progress.ProcessedLogs, time.Since(startTime))
+
+	return nil
 }
 
 // Expensive operation of retrieving all event logs between two blocks. Whenever a "job" is sent to the worker,
-// it first processes it, then waits for another.
-func (me *ethClientBalanceService) worker(workerId int, jobs <-chan job, errChan chan error) {
+// it first processes it, then waits for another. The worker stops once jobs is closed, the context of its job is
+// cancelled or a job failed, in which case the error is passed to fail.
+func (me *ethClientBalanceService) worker(workerId int, jobs <-chan job, fail func(err error)) {
 	log.Printf("Worker %d ready", workerId)
 
 	for job := range jobs {
+		// The jobs left in the channel belong to a cancelled scan
+		if job.ctx.Err() != nil {
+			return
+		}
+
 		// Find events "Transfer" on all defined ERC20's smart contracts
 		query := ethereum.FilterQuery{
 			Addresses: me.smartContractAddresses(),
@@ -169,55 +524,42 @@ func (me *ethClientBalanceService) worker(workerId int, jobs <-chan job, errChan
 
 		logs, err := me.ethClient.FilterLogs(job.ctx, query)
 		if err != nil {
-			errChan <- err
-		}
-
-		for _, eventLog := range logs {
-			tokenCode, found := me.smartContractTokensMap[eventLog.Address.Hex()]
-			if !found {
-				log.Printf("Token %s not found, we don't have a mapping to smart contract. address %s", tokenCode, eventLog.Address.Hex())
-				continue
-			}
-
-			me.balanceLock.Lock()
-
-			transferEvent, err := me.parseTransferEventFromLog(eventLog)
-			if err != nil {
-				errChan <- err
-			}
-
-			balanceInterface, _ := job.balancesMap.LoadOrStore(tokenCode, big.NewInt(0))
-			addressBalance := balanceInterface.(*big.Int)
-
-			if transferEvent.IsReceiver(job.address) {
-				newBalance := big.NewInt(0).Add(addressBalance, transferEvent.Value)
-				log.Printf("Detected an incoming transfer of %d %s (txHash %s). New balance: %d", transferEvent.Value, tokenCode, eventLog.TxHash.Hex(), newBalance)
-				job.balancesMap.Store(tokenCode, newBalance)
-			}
-
-			if transferEvent.IsSender(job.address) {
-				newBalance := big.NewInt(0).Sub(addressBalance, transferEvent.Value)
-				log.Printf("Detected an outgoing transfer of %d %s (txHash %s). New balance: %d", transferEvent.Value, tokenCode, eventLog.TxHash.Hex(), newBalance)
-				job.balancesMap.Store(tokenCode, newBalance)
+			// Failing because the scan is stopped is not an error of the worker
+			if job.ctx.Err() == nil {
+				fail(fmt.Errorf("retrieving the logs of blocks %d to %d. error: %v", job.startBlock, job.endBlock, err))
 			}
+			return
+		}
 
-			me.balanceLock.Unlock()
+		err = job.handleLogs(logs)
+		if err != nil {
+			fail(err)
+			return
 		}
 
-		job.jobsDoneChan <- true
+		job.jobsDoneChan <- scannedChunk{startBlock: job.startBlock, endBlock: job.endBlock, logs: len(logs)}
 	}
 }
 
-func (me *ethClientBalanceService) parseTransferEventFromLog(eventLog types.Log) (blockchain.ERC20TransferEvent, error) {
+// Returns nil and an error if eventLog is not an ERC20 "Transfer" event, e.g. an ERC721 one, which has the same
+// signature but an indexed value
+func (me *ethClientBalanceService) parseTransferEventFromLog(eventLog types.Log) (*blockchain.ERC20TransferEvent, error) {
+	if len(eventLog.Topics) != 3 {
+		return nil, fmt.Errorf("'Transfer' of transaction %s has %d topics instead of 3", eventLog.TxHash.Hex(), len(eventLog.Topics))
+	}
+
 	transferEvent := blockchain.ERC20TransferEvent{}
 	err := me.erc20.Unpack(&transferEvent, "Transfer", eventLog.Data)
 	if err != nil {
-		return transferEvent, fmt.Errorf("unpacking 'Transfer' from Data from transaction %s. Error %v", eventLog.TxHash.Hex(), err)
+		return nil, fmt.Errorf("unpacking 'Transfer' from Data from transaction %s. Error %v", eventLog.TxHash.Hex(), err)
+	}
+	if transferEvent.Value == nil {
+		return nil, fmt.Errorf("'Transfer' of transaction %s has no value", eventLog.TxHash.Hex())
 	}
 
 	transferEvent.From = common.BytesToAddress(eventLog.Topics[1].Bytes())
 	transferEvent.To = common.BytesToAddress(eventLog.Topics[2].Bytes())
-	return transferEvent, nil
+	return &transferEvent, nil
 }
 
 func (me *ethClientBalanceService) smartContractAddresses() []common.Address {
@@ -233,8 +575,8 @@ func (me *ethClientBalanceService) smartContractAddresses() []common.Address {
 }
 
 /*
-   Splits blocks in chunks of size. For example, given startBlock 10, toBlock 30 and size 3
-   should return an array with  [10, 13], [14, 17], [18, 21],..
+   Splits blocks in chunks of size blocks, the first chunk includes startBlock on top of them and is one block longer.
+   For example, given startBlock 10, toBlock 30 and size 3 should return an array with [10, 13], [14, 16], [17, 19],..
 */
 func (me *ethClientBalanceService) getBlockChunks(startBlock *big.Int, toBlock *big.Int, size int) ([]*big.Int, []*big.Int, error) {
 	if startBlock == nil || toBlock == nil {
@@ -244,25 +586,18 @@ func (me *ethClientBalanceService) getBlockChunks(startBlock *big.Int, toBlock *
 	var startBlocks []*big.Int
 	var toBlocks []*big.Int
 
-	counter := 0
-	for i := startBlock; i.CmpAbs(toBlock) < 0; i.Add(i, big.NewInt(int64(size))) {
-		startBlockTemp := new(big.Int).Set(i) // we want it by BigIntValue not reference
-		toBlockTemp := new(big.Int).Set(i)
-		toBlockTemp.Add(toBlockTemp, big.NewInt(int64(size)))
-
-		if toBlockTemp.Cmp(toBlock) > 0 {
-			toBlockTemp.Set(toBlock)
+	chunkStart := new(big.Int).Set(startBlock) // we want it by value not reference
+	chunkEnd := new(big.Int).Set(startBlock)
+	for chunkStart.Cmp(toBlock) <= 0 {
+		chunkEnd = new(big.Int).Add(chunkEnd, big.NewInt(int64(size)))
+		if chunkEnd.Cmp(toBlock) > 0 {
+			chunkEnd.Set(toBlock)
 		}
 
-		if counter == 0 {
-			startBlocks = append(startBlocks, startBlockTemp)
-		} else {
-			startBlockTemp.Add(startBlockTemp, big.NewInt(1))
-			startBlocks = append(startBlocks, startBlockTemp)
-		}
+		startBlocks = append(startBlocks, chunkStart)
+		toBlocks = append(toBlocks, chunkEnd)
 
-		toBlocks = append(toBlocks, toBlockTemp)
-		counter++
+		chunkStart = new(big.Int).Add(chunkEnd, big.NewInt(1))
 	}
 
 	return startBlocks, toBlocks, nil
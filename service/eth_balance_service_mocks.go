@@ -1,7 +1,9 @@
 package service
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"math/big"
 	"strings"
 
@@ -14,9 +16,10 @@ import (
 )
 
 type ethClientStub struct {
-	EthBalance *big.Int
-	XESBalance *big.Int
-	erc20ABI   abi.ABI
+	EthBalance   *big.Int
+	XESBalance   *big.Int
+	erc20ABI     abi.ABI
+	multicallABI abi.ABI
 }
 
 func NewEthClientStub() *ethClientStub {
@@ -25,21 +28,53 @@ func NewEthClientStub() *ethClientStub {
 		panic(err)
 	}
 
+	multicallABI, err := abi.JSON(strings.NewReader(blockchain.MulticallABI))
+	if err != nil {
+		panic(err)
+	}
+
 	xesBalance := big.Int{}
 	xesBalance.SetString("77524316000000000000000000", 10)
 
 	return &ethClientStub{
-		EthBalance: big.NewInt(12345674000000000),
-		XESBalance: &xesBalance,
-		erc20ABI:   erc20ABI,
+		EthBalance:   big.NewInt(12345674000000000),
+		XESBalance:   &xesBalance,
+		erc20ABI:     erc20ABI,
+		multicallABI: multicallABI,
 	}
 }
 
-func (me ethClientStub) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+const (
+	stubGenesisTime = 1438269973 // Timestamp of the stub's block 0
+	stubBlockTime   = 15         // Seconds between two stub blocks
+	stubHeadBlock   = 600        // Latest block of the stub
+	// The stub Multicall contract has no code before this block
+	stubMulticallDeployBlock = 400
+)
+
+func (me ethClientStub) BlockHeaderByNumber(ctx context.Context, number *big.Int) (*BlockHeader, error) {
+	if number == nil {
+		number = big.NewInt(stubHeadBlock)
+	}
+	if number.Cmp(big.NewInt(stubHeadBlock)) > 0 {
+		return nil, ethereum.NotFound
+	}
 
-	return &types.Header{
-		Number: big.NewInt(600),
-	}, nil
+	header := &types.Header{
+		Number: new(big.Int).Set(number),
+		Time:   stubGenesisTime + number.Uint64()*stubBlockTime,
+	}
+	return &BlockHeader{Header: header, Hash: stubBlockHash(header)}, nil
+}
+
+// Stands for the hash returned by a node: differs for every block number and extra data
+func stubBlockHash(header *types.Header) common.Hash {
+	return common.BytesToHash(append([]byte("stub block "+header.Number.String()), header.Extra...))
+}
+
+// The stub chain is mainnet, with the stub's own genesis block
+func (me ethClientStub) ChainID(ctx context.Context) (*big.Int, error) {
+	return big.NewInt(1), nil
 }
 
 func (me ethClientStub) BalanceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (*big.Int, error) {
@@ -47,6 +82,85 @@ func (me ethClientStub) BalanceAt(ctx context.Context, account common.Address, b
 	return me.EthBalance, nil
 }
 
+// Answers "balanceOf" and "decimals" (18) calls: the target address holds XESBalance on the XES contract, nothing anywhere else.
+// Calls to any other address than XES, MKR or the Multicall contract behave like calls to an address without code.
+func (me ethClientStub) CallContract(ctx context.Context, msg ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	if msg.To != nil && *msg.To == common.HexToAddress(blockchain.Multicall3Address) {
+		return me.callMulticall(ctx, msg, blockNumber)
+	}
+
+	xesSmartContractAddress := common.HexToAddress("0xA017ac5faC5941f95010b12570B812C974469c2C")
+	mkrSmartContractAddress := common.HexToAddress("0x9f8F72aA9304c8B593d555F12eF6589cC3A579A2")
+	targetAddress := common.HexToAddress("0x043129ab3945D2bB75f3B5DE21487343EFBeffd2")
+
+	if msg.To == nil || (*msg.To != xesSmartContractAddress && *msg.To != mkrSmartContractAddress) {
+		return nil, nil
+	}
+
+	decimals := me.erc20ABI.Methods["decimals"]
+	if len(msg.Data) == 4 && bytes.Equal(msg.Data, decimals.ID()) {
+		return decimals.Outputs.Pack(uint8(18))
+	}
+
+	balanceOf := me.erc20ABI.Methods["balanceOf"]
+	if len(msg.Data) < 4 || !bytes.Equal(msg.Data[:4], balanceOf.ID()) {
+		return nil, errors.New("execution reverted")
+	}
+
+	balance := big.NewInt(0)
+	if *msg.To == xesSmartContractAddress && bytes.Equal(msg.Data[4:], common.LeftPadBytes(targetAddress.Bytes(), 32)) {
+		balance = me.XESBalance
+	}
+	return balanceOf.Outputs.Pack(balance)
+}
+
+// Executes the sub-calls of "aggregate" one by one, "getEthBalance" returns EthBalance
+func (me ethClientStub) callMulticall(ctx context.Context, msg ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	if blockNumber != nil && blockNumber.Cmp(big.NewInt(stubMulticallDeployBlock)) < 0 {
+		return nil, nil
+	}
+
+	aggregate := me.multicallABI.Methods["aggregate"]
+	getEthBalance := me.multicallABI.Methods["getEthBalance"]
+	if len(msg.Data) < 4 || !bytes.Equal(msg.Data[:4], aggregate.ID()) {
+		return nil, errors.New("execution reverted")
+	}
+
+	// aggregate((address,bytes)[]): offset of the array, its length, then the offset of every call
+	args := msg.Data[4:]
+	word := func(data []byte, offset uint64) uint64 {
+		return new(big.Int).SetBytes(data[offset : offset+32]).Uint64()
+	}
+	callsData := args[word(args, 0):]
+	callsCount := word(callsData, 0)
+	callsData = callsData[32:]
+
+	var returnData [][]byte
+	for i := uint64(0); i < callsCount; i++ {
+		callData := callsData[word(callsData, i*32):]
+		target := common.BytesToAddress(callData[:32])
+		inputData := callData[word(callData, 32):]
+		input := inputData[32 : 32+word(inputData, 0)]
+
+		var output []byte
+		var err error
+		if target == *msg.To && bytes.Equal(input[:4], getEthBalance.ID()) {
+			output, err = getEthBalance.Outputs.Pack(me.EthBalance)
+		} else {
+			output, err = me.CallContract(ctx, ethereum.CallMsg{To: &target, Data: input}, blockNumber)
+		}
+		if err != nil {
+			return nil, err
+		}
+		returnData = append(returnData, output)
+	}
+
+	if blockNumber == nil {
+		blockNumber = big.NewInt(600)
+	}
+	return aggregate.Outputs.Pack(blockNumber, returnData)
+}
+
 func (me ethClientStub) FilterLogs(ctx context.Context, q ethereum.FilterQuery) ([]types.Log, error) {
 	xesSmartContractAddress := common.HexToAddress("0xA017ac5faC5941f95010b12570B812C974469c2C")
 	targetAddress := common.HexToHash("0x043129ab3945D2bB75f3B5DE21487343EFBeffd2")
@@ -61,7 +175,7 @@ func (me ethClientStub) FilterLogs(ctx context.Context, q ethereum.FilterQuery)
 	thirdTransferValue := big.Int{}
 	thirdTransferValue.SetString("2500000000000000000000000000", 10)
 
-	return []types.Log{
+	logs := []types.Log{
 		// incoming XES transfer
 		{
 			Address: xesSmartContractAddress,
@@ -111,8 +225,97 @@ func (me ethClientStub) FilterLogs(ctx context.Context, q ethereum.FilterQuery)
 			},
 			Data:        common.BytesToHash(thirdTransferValue.Bytes()).Bytes(),
 			BlockNumber: 507,
-			TxHash:      common.HexToHash("0x140200e1f5b8ebd7bc3147c50437e5e74600959e58b5c4e1a1da2803e1b8663c"),
+			TxHash:      common.HexToHash("0x240200e1f5b8ebd7bc3147c50437e5e74600959e58b5c4e1a1da2803e1b8663c"),
 			Removed:     false,
 		},
-	}, nil
+		// an incoming XES transfer that was removed by a chain reorganisation, it must be ignored
+		{
+			Address: xesSmartContractAddress,
+			Topics: []common.Hash{
+				common.BytesToHash(crypto.Keccak256(me.erc20ABI.Methods["transfer"].ID())),
+				common.HexToHash("0xef91ecd0142ae4c5163b2cf060c0563d49188c82"),
+				targetAddress,
+			},
+			Data:        common.BytesToHash(firstTransferValue.Bytes()).Bytes(),
+			BlockNumber: 505,
+			TxHash:      common.HexToHash("0x340200e1f5b8ebd7bc3147c50437e5e74600959e58b5c4e1a1da2803e1b8663c"),
+			Removed:     true,
+		},
+	}
+
+	// Only return the logs inside the queried block range, as a real node would do
+	var filteredLogs []types.Log
+	for _, eventLog := range logs {
+		blockNumber := new(big.Int).SetUint64(eventLog.BlockNumber)
+		if q.FromBlock != nil && blockNumber.Cmp(q.FromBlock) < 0 {
+			continue
+		}
+		if q.ToBlock != nil && blockNumber.Cmp(q.ToBlock) > 0 {
+			continue
+		}
+		filteredLogs = append(filteredLogs, eventLog)
+	}
+
+	return filteredLogs, nil
+}
+
+// Simulates a chain reorganisation replacing all the blocks from reorgBlock onwards once reorged is set: their hashes
+// change and the logs they contained are gone.
+type reorgEthClientStub struct {
+	*ethClientStub
+	reorgBlock uint64
+	reorged    bool
+}
+
+func (me *reorgEthClientStub) BlockHeaderByNumber(ctx context.Context, number *big.Int) (*BlockHeader, error) {
+	header, err := me.ethClientStub.BlockHeaderByNumber(ctx, number)
+	if err != nil {
+		return nil, err
+	}
+
+	if me.reorged && header.Number.Uint64() >= me.reorgBlock {
+		header.Extra = []byte("reorg")
+		header.Hash = stubBlockHash(header.Header)
+	}
+	return header, nil
+}
+
+func (me *reorgEthClientStub) FilterLogs(ctx context.Context, q ethereum.FilterQuery) ([]types.Log, error) {
+	logs, err := me.ethClientStub.FilterLogs(ctx, q)
+	if err != nil || !me.reorged {
+		return logs, err
+	}
+
+	var remainingLogs []types.Log
+	for _, eventLog := range logs {
+		if eventLog.BlockNumber < me.reorgBlock {
+			remainingLogs = append(remainingLogs, eventLog)
+		}
+	}
+	return remainingLogs, nil
+}
+
+// Never returns logs, FilterLogs blocks until its context is cancelled
+type blockingEthClientStub struct {
+	*ethClientStub
+	filterLogsCalls chan bool
+}
+
+func (me *blockingEthClientStub) FilterLogs(ctx context.Context, q ethereum.FilterQuery) ([]types.Log, error) {
+	me.filterLogsCalls <- true
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+// FilterLogs fails for the chunk containing failingBlock
+type failingEthClientStub struct {
+	*ethClientStub
+	failingBlock uint64
+}
+
+func (me *failingEthClientStub) FilterLogs(ctx context.Context, q ethereum.FilterQuery) ([]types.Log, error) {
+	if q.FromBlock.Uint64() <= me.failingBlock && me.failingBlock <= q.ToBlock.Uint64() {
+		return nil, errors.New("eth error")
+	}
+	return me.ethClientStub.FilterLogs(ctx, q)
 }
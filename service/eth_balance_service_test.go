@@ -2,10 +2,16 @@ package service
 
 import (
 	"context"
+	"errors"
 	"math/big"
+	"runtime"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -17,7 +23,7 @@ func TestEthClientBalanceService_parseTransferEventFromLog(t *testing.T) {
 		"0x9f8F72aA9304c8B593d555F12eF6589cC3A579A2": "MKR",
 	}
 
-	balanceService, err := NewEthClientBalanceService(NewEthClientStub(), tokensMap)
+	balanceService, err := NewEthClientBalanceService(NewEthClientStub(), tokensMap, 0, 0)
 	assert.Nil(t, err)
 
 	balances, err := balanceService.GetBalancesForAddress(ctx, "0x043129ab3945D2bB75f3B5DE21487343EFBeffd2")
@@ -36,6 +42,293 @@ func TestEthClientBalanceService_parseTransferEventFromLog(t *testing.T) {
 	assert.Equal(t, &expectedXES, xesBalance)
 }
 
+func TestEthClientBalanceService_GetBalancesForAddressAtBlock(t *testing.T) {
+	ctx := context.Background()
+
+	tokensMap := map[string]string{
+		"0xA017ac5faC5941f95010b12570B812C974469c2C": "XES",
+	}
+
+	balanceService, err := NewEthClientBalanceService(NewEthClientStub(), tokensMap, 0, 0)
+	assert.Nil(t, err)
+
+	// Only the two incoming transfers (blocks 500 and 505) happened before block 506
+	result, err := balanceService.GetBalancesForAddressAtBlock(ctx, "0x043129ab3945D2bB75f3B5DE21487343EFBeffd2", big.NewInt(506))
+	assert.Nil(t, err)
+	balances, block := result.Balances, result.Block
+	assert.Equal(t, big.NewInt(506), block.Number)
+	assert.True(t, block.Confirmed)
+
+	xesBalance, xesFound := balances.Load("XES")
+	assert.True(t, xesFound)
+
+	expectedXES := big.Int{}
+	expectedXES.SetString("6500000000000000000000000000", 10)
+	assert.Equal(t, &expectedXES, xesBalance)
+}
+
+func TestEthClientBalanceService_GetBalancesForAddressFromStartBlock(t *testing.T) {
+	ctx := context.Background()
+
+	tokensMap := map[string]string{
+		"0xA017ac5faC5941f95010b12570B812C974469c2C": "XES",
+	}
+
+	// The transfer of block 500 is before the start block and isn't scanned
+	balanceService, err := NewEthClientBalanceService(NewEthClientStub(), tokensMap, 0, 501)
+	assert.Nil(t, err)
+
+	result, err := balanceService.GetBalancesForAddressAtBlock(ctx, "0x043129ab3945D2bB75f3B5DE21487343EFBeffd2", big.NewInt(506))
+	assert.Nil(t, err)
+	balances := result.Balances
+
+	xesBalance, xesFound := balances.Load("XES")
+	assert.True(t, xesFound)
+
+	expectedXES := big.Int{}
+	expectedXES.SetString("5500000000000000000000000000", 10)
+	assert.Equal(t, &expectedXES, xesBalance)
+}
+
+func TestEthClientBalanceService_GetBalancesForAddressWithTransferIndex(t *testing.T) {
+	ctx := context.Background()
+
+	tokensMap := map[string]string{
+		"0xA017ac5faC5941f95010b12570B812C974469c2C": "XES",
+		"0x9f8F72aA9304c8B593d555F12eF6589cC3A579A2": "MKR",
+	}
+
+	transferIndex := newTestTransferIndex(t)
+
+	balanceService, err := NewIndexedEthClientBalanceService(NewEthClientStub(), tokensMap, 0, 0, transferIndex)
+	assert.Nil(t, err)
+
+	// First request indexes blocks 0 to 506
+	result, err := balanceService.GetBalancesForAddressAtBlock(ctx, "0x043129ab3945D2bB75f3B5DE21487343EFBeffd2", big.NewInt(506))
+	assert.Nil(t, err)
+	balances := result.Balances
+
+	xesBalance, _ := balances.Load("XES")
+	expectedXES := big.Int{}
+	expectedXES.SetString("6500000000000000000000000000", 10)
+	assert.Equal(t, &expectedXES, xesBalance)
+
+	mkrBalance, mkrFound := balances.Load("MKR")
+	assert.True(t, mkrFound)
+	assert.Equal(t, big.NewInt(0), mkrBalance)
+
+	// Second request only indexes blocks 507 to 600
+	balances, err = balanceService.GetBalancesForAddress(ctx, "0x043129ab3945D2bB75f3B5DE21487343EFBeffd2")
+	assert.Nil(t, err)
+
+	xesBalance, _ = balances.Load("XES")
+	expectedXES.SetString("4000000000000000000000000000", 10)
+	assert.Equal(t, &expectedXES, xesBalance)
+}
+
+func TestEthClientBalanceService_confirmationDepth(t *testing.T) {
+	ctx := context.Background()
+
+	tokensMap := map[string]string{
+		"0xA017ac5faC5941f95010b12570B812C974469c2C": "XES",
+	}
+
+	transferIndex := newTestTransferIndex(t)
+
+	// The stub's last block is 600, so blocks 501 to 600 are provisional
+	balanceService, err := NewIndexedEthClientBalanceService(NewEthClientStub(), tokensMap, 100, 0, transferIndex)
+	assert.Nil(t, err)
+
+	result, err := balanceService.GetBalancesForAddressAtBlock(ctx, "0x043129ab3945D2bB75f3B5DE21487343EFBeffd2", nil)
+	assert.Nil(t, err)
+	balances, block := result.Balances, result.Block
+	assert.Equal(t, big.NewInt(600), block.Number)
+	assert.False(t, block.Confirmed)
+
+	xesBalance, _ := balances.Load("XES")
+	expectedXES := big.Int{}
+	expectedXES.SetString("4000000000000000000000000000", 10)
+	assert.Equal(t, &expectedXES, xesBalance)
+
+	// Provisional blocks are not indexed
+	assert.Equal(t, uint64(501), transferIndex.nextBlockToIndex([]string{"0xA017ac5faC5941f95010b12570B812C974469c2C"}))
+}
+
+func TestEthClientBalanceService_reorg(t *testing.T) {
+	ctx := context.Background()
+
+	tokensMap := map[string]string{
+		"0xA017ac5faC5941f95010b12570B812C974469c2C": "XES",
+	}
+
+	transferIndex := newTestTransferIndex(t)
+
+	ethClient := &reorgEthClientStub{ethClientStub: NewEthClientStub(), reorgBlock: 507}
+	balanceService, err := NewIndexedEthClientBalanceService(ethClient, tokensMap, 0, 0, transferIndex)
+	assert.Nil(t, err)
+
+	_, err = balanceService.GetBalancesForAddressAtBlock(ctx, "0x043129ab3945D2bB75f3B5DE21487343EFBeffd2", big.NewInt(506))
+	assert.Nil(t, err)
+
+	balances, err := balanceService.GetBalancesForAddress(ctx, "0x043129ab3945D2bB75f3B5DE21487343EFBeffd2")
+	assert.Nil(t, err)
+
+	xesBalance, _ := balances.Load("XES")
+	expectedXES := big.Int{}
+	expectedXES.SetString("4000000000000000000000000000", 10)
+	assert.Equal(t, &expectedXES, xesBalance)
+
+	// The outgoing transfer of block 507 disappears, blocks 507 to 600 have to be indexed again
+	ethClient.reorged = true
+
+	result, err := balanceService.GetBalancesForAddressAtBlock(ctx, "0x043129ab3945D2bB75f3B5DE21487343EFBeffd2", nil)
+	assert.Nil(t, err)
+	balances, block := result.Balances, result.Block
+
+	headHeader, _ := ethClient.BlockHeaderByNumber(ctx, nil)
+	assert.Equal(t, headHeader.Hash, block.Hash)
+
+	xesBalance, _ = balances.Load("XES")
+	expectedXES.SetString("6500000000000000000000000000", 10)
+	assert.Equal(t, &expectedXES, xesBalance)
+}
+
+func TestEthClientBalanceService_reorgToShorterChain(t *testing.T) {
+	ctx := context.Background()
+	const contract = "0xA017ac5faC5941f95010b12570B812C974469c2C"
+
+	transferIndex := newTestTransferIndex(t)
+
+	ethClient := NewEthClientStub()
+	balanceService, err := NewIndexedEthClientBalanceService(ethClient, map[string]string{contract: "XES"}, 0, 0, transferIndex)
+	assert.Nil(t, err)
+
+	// Block 700 was indexed on a chain that got replaced by a chain ending at block 600
+	header, err := ethClient.BlockHeaderByNumber(ctx, big.NewInt(500))
+	assert.Nil(t, err)
+	assert.Nil(t, transferIndex.add(nil, []string{contract}, 500, header.Hash.Hex()))
+	assert.Nil(t, transferIndex.add(nil, []string{contract}, 700, "0x700"))
+
+	err = balanceService.checkTransferIndexForReorg(ctx)
+	assert.Nil(t, err)
+
+	assert.Equal(t, uint64(501), transferIndex.nextBlockToIndex([]string{contract}))
+	assert.Equal(t, []transferIndexCheckpoint{{blockNumber: 500, blockHash: header.Hash.Hex()}}, transferIndex.checkpoints())
+}
+
+func TestEthClientBalanceService_scanProgress(t *testing.T) {
+	tokensMap := map[string]string{
+		"0xA017ac5faC5941f95010b12570B812C974469c2C": "XES",
+	}
+	balanceService, err := NewEthClientBalanceService(NewEthClientStub(), tokensMap, 0, 0)
+	assert.Nil(t, err)
+
+	var lock sync.Mutex
+	var progresses []ScanProgress
+	ctx := WithScanProgress(context.Background(), func(progress ScanProgress) {
+		lock.Lock()
+		defer lock.Unlock()
+		progresses = append(progresses, progress)
+	})
+
+	// Chunks [0, 600], [601, 1200] and [1201, 1300], the first one is one block longer. The stub logs are all in the
+	// first one
+	err = balanceService.scanTransferLogs(ctx, big.NewInt(0), big.NewInt(1300), func(logs []types.Log) error {
+		return nil
+	})
+	assert.Nil(t, err)
+
+	assert.Len(t, progresses, 4)
+	assert.Equal(t, uint64(0), progresses[0].ScannedBlocks)
+	assert.Equal(t, 3, progresses[0].TotalChunks)
+	last := progresses[3]
+	assert.NotZero(t, last.ScanID)
+	assert.Equal(t, 3, last.ScannedChunks)
+	assert.Equal(t, uint64(1301), last.ScannedBlocks)
+	assert.Equal(t, uint64(1301), last.TotalBlocks)
+	assert.Equal(t, uint64(5), last.ProcessedLogs)
+	assert.Equal(t, time.Duration(0), last.ETA)
+}
+
+func TestEthClientBalanceService_scanCancellation(t *testing.T) {
+	ethClient := &blockingEthClientStub{ethClientStub: NewEthClientStub(), filterLogsCalls: make(chan bool, 100)}
+	balanceService, err := NewEthClientBalanceService(ethClient, map[string]string{}, 0, 0)
+	assert.Nil(t, err)
+
+	goroutines := runtime.NumGoroutine()
+	ctx, cancel := context.WithCancel(context.Background())
+	errChan := make(chan error)
+	go func() {
+		errChan <- balanceService.scanTransferLogs(ctx, big.NewInt(0), big.NewInt(1000000), func(logs []types.Log) error {
+			return nil
+		})
+	}()
+
+	<-ethClient.filterLogsCalls
+	cancel()
+	select {
+	case err := <-errChan:
+		assert.Equal(t, context.Canceled, err)
+	case <-time.After(time.Second * 5):
+		t.Fatal("scan not cancelled")
+	}
+
+	// The workers and the producer of jobs stop
+	assertGoroutinesStopped(t, goroutines)
+}
+
+// Goroutines take a moment to exit once they're done, waits up to 5 seconds for their count to go back to goroutines
+func assertGoroutinesStopped(t *testing.T, goroutines int) {
+	deadline := time.Now().Add(time.Second * 5)
+	for runtime.NumGoroutine() > goroutines && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 10)
+	}
+	assert.True(t, runtime.NumGoroutine() <= goroutines, "%d goroutines still running, expected at most %d", runtime.NumGoroutine(), goroutines)
+}
+
+func TestEthClientBalanceService_scanError(t *testing.T) {
+	ethClient := &failingEthClientStub{ethClientStub: NewEthClientStub(), failingBlock: 50000}
+	balanceService, err := NewEthClientBalanceService(ethClient, map[string]string{}, 0, 0)
+	assert.Nil(t, err)
+
+	goroutines := runtime.NumGoroutine()
+	var returned int32
+	err = balanceService.scanTransferLogs(context.Background(), big.NewInt(0), big.NewInt(1000000), func(logs []types.Log) error {
+		assert.Zero(t, atomic.LoadInt32(&returned), "logs handled after the scan returned")
+		return nil
+	})
+	atomic.StoreInt32(&returned, 1)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "eth error")
+	assertGoroutinesStopped(t, goroutines)
+
+	t.Run("ShouldReturnErrorOfLogsHandler", func(t *testing.T) {
+		err := balanceService.scanTransferLogs(context.Background(), big.NewInt(0), big.NewInt(10000), func(logs []types.Log) error {
+			return errors.New("handler error")
+		})
+		assert.Equal(t, errors.New("handler error"), err)
+	})
+}
+
+func TestEthClientBalanceService_parseInvalidTransferEvent(t *testing.T) {
+	balanceService, err := NewEthClientBalanceService(NewEthClientStub(), map[string]string{}, 0, 0)
+	assert.Nil(t, err)
+
+	// ERC721 "Transfer" events have the same signature, with the token id as fourth topic and no data
+	transferEvent, err := balanceService.parseTransferEventFromLog(types.Log{
+		Topics: []common.Hash{balanceService.erc20.Events["Transfer"].ID(), {}, {}, {}},
+	})
+	assert.NotNil(t, err)
+	assert.Nil(t, transferEvent)
+
+	transferEvent, err = balanceService.parseTransferEventFromLog(types.Log{
+		Topics: []common.Hash{balanceService.erc20.Events["Transfer"].ID(), {}, {}},
+		Data:   []byte{1},
+	})
+	assert.NotNil(t, err)
+	assert.Nil(t, transferEvent)
+}
+
 func TestEthClientBalanceService_smartContractAddresses(t *testing.T) {
 	balanceService := ethClientBalanceService{
 		smartContractTokensMap: map[string]string{
@@ -70,6 +363,14 @@ func TestEthereumBlockchainConnector_getBlockChunks(t *testing.T) {
 		assert.Equal(t, []*big.Int{big.NewInt(20), big.NewInt(30), big.NewInt(40), big.NewInt(42)}, endBlocks)
 	})
 
+	t.Run("single block", func(t *testing.T) {
+		startBlocks, endBlocks, err := balanceService.getBlockChunks(big.NewInt(42), big.NewInt(42), 10)
+
+		assert.Nil(t, err)
+		assert.Equal(t, []*big.Int{big.NewInt(42)}, startBlocks)
+		assert.Equal(t, []*big.Int{big.NewInt(42)}, endBlocks)
+	})
+
 	t.Run("nil as toBlock", func(t *testing.T) {
 		_, _, err := balanceService.getBlockChunks(big.NewInt(10), nil, 10)
 
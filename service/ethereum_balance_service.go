@@ -10,33 +10,100 @@ import (
 
 type (
 	EthereumBalanceService interface {
-		GetBalances(ctx context.Context, ethAddress string) (map[string]*big.Float, error)
+		GetBalances(ctx context.Context, ethAddress string) (*TokenBalances, error)
+		// Returns the balances as of the last block mined at or before date
+		GetBalancesAtDate(ctx context.Context, ethAddress string, date time.Time) (*TokenBalances, error)
+		// Returns the balances as of blockNumber, the latest block if nil
+		GetBalancesAtBlock(ctx context.Context, ethAddress string, blockNumber *big.Int) (*TokenBalances, error)
+	}
+
+	// Balances of an address on one chain, by symbol
+	TokenBalances struct {
+		Balances map[string]*TokenAmount
+		// Nil if the balance service doesn't know at which block the balances were calculated
+		Block *BalancesBlock
+		// Problems that didn't prevent retrieving the balances, e.g. ignored tokens impersonating a configured token
+		Warnings []string
 	}
 
 	defaultEthereumBalanceService struct {
-		ethBalanceService EthBalanceService
+		ethBalanceService     EthBalanceService
+		blockResolver         BlockResolver
+		tokenDecimalsResolver TokenDecimalsResolver
+		priceOracle           PriceOracle
+		currency              string
+		nativeSymbol          string
 	}
 )
 
-const defaultEthereumUnit = 1000000000000000000
+// Synchronous requests can't take longer than this
+const requestTimeout = time.Minute * 10
+
+// blockResolver is only needed by GetBalancesAtDate and can be nil if the ethBalanceService doesn't support historical balances.
+// If tokenDecimalsResolver is nil, all the tokens are assumed to have the same decimals as ETH.
+// The balance of the native asset of the chain is returned under nativeSymbol (ETH, MATIC, BNB,..).
+func NewEthereumBalanceService(ethBalanceService EthBalanceService, blockResolver BlockResolver, tokenDecimalsResolver TokenDecimalsResolver, nativeSymbol string) *defaultEthereumBalanceService {
+	return &defaultEthereumBalanceService{
+		ethBalanceService:     ethBalanceService,
+		blockResolver:         blockResolver,
+		tokenDecimalsResolver: tokenDecimalsResolver,
+		nativeSymbol:          nativeSymbol,
+	}
+}
+
+// Same as NewEthereumBalanceService, but the balances carry the price of their token in currency if priceOracle knows
+// it, at the date of the balances: the requested date, the time of the requested block or the latest price. Without
+// priceOracle, the current balances carry the prices returned along with them by ethBalanceService (e.g. Ethplorer).
+func NewValuedEthereumBalanceService(ethBalanceService EthBalanceService, blockResolver BlockResolver, tokenDecimalsResolver TokenDecimalsResolver, priceOracle PriceOracle, currency string, nativeSymbol string) *defaultEthereumBalanceService {
+	balanceService := NewEthereumBalanceService(ethBalanceService, blockResolver, tokenDecimalsResolver, nativeSymbol)
+	balanceService.priceOracle = priceOracle
+	balanceService.currency = currency
+	return balanceService
+}
+
+// Returns the balance of tokens in a map, along with the decimals of each token to convert them to default unit (1 ETH, 1 token)
+func (me *defaultEthereumBalanceService) GetBalances(ctx context.Context, ethAddress string) (*TokenBalances, error) {
+	ctx, cancel := withRequestTimeout(ctx)
+	defer cancel()
+
+	return me.getBalancesAtBlock(ctx, ethAddress, nil, time.Time{})
+}
+
+// Same as GetBalances, but the balances are calculated at the last block mined at or before date
+func (me *defaultEthereumBalanceService) GetBalancesAtDate(ctx context.Context, ethAddress string, date time.Time) (*TokenBalances, error) {
+	if me.blockResolver == nil {
+		return nil, errHistoricalBalancesNotSupported
+	}
+
+	ctx, cancel := withRequestTimeout(ctx)
+	defer cancel()
+
+	blockNumber, err := me.blockResolver.BlockNumberAt(ctx, date)
+	if err != nil {
+		return nil, err
+	}
+
+	return me.getBalancesAtBlock(ctx, ethAddress, blockNumber, date)
+}
+
+func (me *defaultEthereumBalanceService) GetBalancesAtBlock(ctx context.Context, ethAddress string, blockNumber *big.Int) (*TokenBalances, error) {
+	ctx, cancel := withRequestTimeout(ctx)
+	defer cancel()
 
-func NewEthereumBalanceService(ethBalanceService EthBalanceService) *defaultEthereumBalanceService {
-	return &defaultEthereumBalanceService{ethBalanceService: ethBalanceService}
+	return me.getBalancesAtBlock(ctx, ethAddress, blockNumber, time.Time{})
 }
 
-// Returns the balance of tokens in a map. Are converted to default unit, see `defaultEthereumUnit`.
-// This method is only compatible for erc20 tokens that use `defaultEthereumUnit` and ETH
-func (me *defaultEthereumBalanceService) GetBalances(ctx context.Context, ethAddress string) (map[string]*big.Float, error) {
-	response := make(map[string]*big.Float)
+// The balances are valued at date if set
+func (me *defaultEthereumBalanceService) getBalancesAtBlock(ctx context.Context, ethAddress string, blockNumber *big.Int, date time.Time) (*TokenBalances, error) {
+	response := make(map[string]*TokenAmount)
 
-	ctx, cancel := context.WithTimeout(ctx, time.Minute*10)
-	balances, err := me.ethBalanceService.GetBalancesForAddress(ctx, ethAddress)
-	cancel()
+	result, err := me.ethBalanceService.GetBalancesForAddressAtBlock(ctx, ethAddress, blockNumber)
 	if err != nil {
 		return nil, err
 	}
+	priceDate, valued := valuationDate(blockNumber, result.Block, date)
 
-	balances.Range(func(key, value interface{}) bool {
+	result.Balances.Range(func(key, value interface{}) bool {
 		keyString, ok := key.(string)
 		if !ok {
 			err = fmt.Errorf("key expected to be a string, was %v. Value: %v", reflect.TypeOf(key), key)
@@ -49,7 +116,27 @@ func (me *defaultEthereumBalanceService) GetBalances(ctx context.Context, ethAdd
 			return false
 		}
 
-		response[keyString] = me.convertToDefaultUnit(valWei)
+		decimals, decimalsErr := me.decimals(ctx, keyString)
+		if decimalsErr != nil {
+			err = fmt.Errorf("retrieving %s decimals. error: %v", keyString, decimalsErr)
+			return false
+		}
+		returnedPrice := result.Prices[keyString]
+
+		if keyString == NativeAssetKey {
+			keyString = me.nativeSymbol
+		}
+
+		var price *TokenPrice
+		if valued {
+			var priceErr error
+			price, priceErr = me.price(ctx, keyString, priceDate, returnedPrice)
+			if priceErr != nil {
+				err = fmt.Errorf("retrieving %s price. error: %v", keyString, priceErr)
+				return false
+			}
+		}
+		response[keyString] = &TokenAmount{Value: valWei, Decimals: decimals, Price: price}
 		return true
 	})
 
@@ -57,13 +144,52 @@ func (me *defaultEthereumBalanceService) GetBalances(ctx context.Context, ethAdd
 		return nil, err
 	}
 
-	return response, nil
+	return &TokenBalances{Balances: response, Block: result.Block, Warnings: result.Warnings}, nil
+}
+
+// The decimals are resolved even for zero balances, they're part of the response
+func (me *defaultEthereumBalanceService) decimals(ctx context.Context, symbol string) (uint8, error) {
+	if symbol == NativeAssetKey || me.tokenDecimalsResolver == nil {
+		return ethDecimals, nil
+	}
+
+	return me.tokenDecimalsResolver.TokenDecimals(ctx, symbol)
+}
+
+// Nil if the price oracle doesn't know the price of the token at date. Without price oracle, returnedPrice is used if
+// it's a current price in the currency of the service.
+func (me *defaultEthereumBalanceService) price(ctx context.Context, symbol string, date time.Time, returnedPrice *TokenPrice) (*TokenPrice, error) {
+	if me.priceOracle == nil {
+		if returnedPrice == nil || returnedPrice.Currency != me.currency || !date.IsZero() {
+			return nil, nil
+		}
+		return returnedPrice, nil
+	}
+
+	price, err := me.priceOracle.Price(ctx, symbol, me.currency, date)
+	if err == errUnknownTokenPrice {
+		return nil, nil
+	}
+	return price, err
+}
+
+// Date the balances are valued at: the requested date, the time of the requested block or zero for the latest prices.
+// Balances at a block the balance service doesn't know the time of can't be valued.
+func valuationDate(blockNumber *big.Int, block *BalancesBlock, date time.Time) (time.Time, bool) {
+	switch {
+	case !date.IsZero() || blockNumber == nil:
+		return date, true
+	case block != nil && !block.Time.IsZero():
+		return block.Time, true
+	default:
+		return time.Time{}, false
+	}
 }
 
-func (me *defaultEthereumBalanceService) convertToDefaultUnit(value *big.Int) *big.Float {
-	val, ok := big.NewFloat(0).SetString(value.String())
-	if !ok {
-		return big.NewFloat(0)
+// Limits ctx to requestTimeout, unless the caller already set a deadline (e.g. asynchronous jobs)
+func withRequestTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok {
+		return context.WithCancel(ctx)
 	}
-	return big.NewFloat(0).Quo(val, big.NewFloat(defaultEthereumUnit))
+	return context.WithTimeout(ctx, requestTimeout)
 }
@@ -2,27 +2,71 @@ package service
 
 import (
 	"context"
+	"math/big"
 	"sync"
+	"time"
 )
 
 type (
 	ethBalanceStub struct {
 	}
+
+	tokenDecimalsStub map[string]uint8
+
+	// token/currency -> price, unknown before its timestamp
+	priceOracleStub map[string]*TokenPrice
 )
 
-func (me *ethBalanceStub) GetBalancesForAddress(ctx context.Context, _ string) (*sync.Map, error) {
+func (me *ethBalanceStub) GetBalancesForAddress(ctx context.Context, address string) (*sync.Map, error) {
+	result, err := me.GetBalancesForAddressAtBlock(ctx, address, nil)
+	if err != nil {
+		return nil, err
+	}
+	return result.Balances, nil
+}
+
+func (me *ethBalanceStub) GetBalancesForAddressAtBlock(ctx context.Context, _ string, _ *big.Int) (*BlockBalances, error) {
 	var (
 		returnMap sync.Map
-		returnErr error
+		result    BlockBalances
 	)
 
 	if ctx.Value("returnMap") != nil {
 		returnMap = ctx.Value("returnMap").(sync.Map)
 	}
+	result.Balances = &returnMap
 
 	if ctx.Value("returnErr") != nil {
-		returnErr = ctx.Value("returnErr").(error)
+		return nil, ctx.Value("returnErr").(error)
+	}
+
+	if ctx.Value("returnWarning") != nil {
+		result.Warnings = []string{ctx.Value("returnWarning").(string)}
+	}
+
+	if ctx.Value("returnBlock") != nil {
+		result.Block = ctx.Value("returnBlock").(*BalancesBlock)
 	}
 
-	return &returnMap, returnErr
+	if ctx.Value("returnPrices") != nil {
+		result.Prices = ctx.Value("returnPrices").(map[string]*TokenPrice)
+	}
+
+	return &result, nil
+}
+
+func (me tokenDecimalsStub) TokenDecimals(ctx context.Context, symbol string) (uint8, error) {
+	decimals, ok := me[symbol]
+	if !ok {
+		return 0, errUnknownTokenDecimals
+	}
+	return decimals, nil
+}
+
+func (me priceOracleStub) Price(ctx context.Context, symbol, currency string, date time.Time) (*TokenPrice, error) {
+	price, ok := me[symbol+"/"+currency]
+	if !ok || (!date.IsZero() && price.Timestamp.After(date)) {
+		return nil, errUnknownTokenPrice
+	}
+	return price, nil
 }
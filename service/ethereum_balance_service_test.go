@@ -6,11 +6,12 @@ import (
 	"math/big"
 	"sync"
 	"testing"
+	"time"
 )
 
 func TestDefaultTaxReporterService_GetBalances(t *testing.T) {
 	ethBalanceStub := &ethBalanceStub{}
-	taxReporter := NewEthereumBalanceService(ethBalanceStub)
+	taxReporter := NewEthereumBalanceService(ethBalanceStub, nil, nil, "ETH")
 
 	t.Run("ShouldReturnETHandXesBalance", func(t *testing.T) {
 
@@ -30,19 +31,161 @@ func TestDefaultTaxReporterService_GetBalances(t *testing.T) {
 		taxReporterBalances, err := taxReporter.GetBalances(ctx, "0x1")
 
 		if err != nil {
-			t.Error(err)
+			t.Fatal(err)
 		}
-		if taxReporterBalances["ETH"].Cmp(big.NewFloat(0.000000001231230982)) != 0 {
-			t.Errorf("expected ETH to be %s but got %s", "0.000000001231230982", taxReporterBalances["ETH"])
+		if taxReporterBalances.Balances["ETH"].Float().Cmp(big.NewFloat(0.000000001231230982)) != 0 {
+			t.Errorf("expected ETH to be %s but got %s", "0.000000001231230982", taxReporterBalances.Balances["ETH"])
 		}
-		if taxReporterBalances["XES"].Cmp(big.NewFloat(278797678)) != 0 {
-			t.Errorf("expected XES to be %s but got %s", "278797678", taxReporterBalances["XES"])
+		if taxReporterBalances.Balances["XES"].Float().Cmp(big.NewFloat(278797678)) != 0 {
+			t.Errorf("expected XES to be %s but got %s", "278797678", taxReporterBalances.Balances["XES"])
 		}
-		if taxReporterBalances["MKR"] != nil {
+		if taxReporterBalances.Balances["MKR"] != nil {
 			t.Error("Expected MKR to be nil")
 		}
 	})
 
+	t.Run("ShouldUseTokenDecimals", func(t *testing.T) {
+		taxReporter := NewEthereumBalanceService(ethBalanceStub, nil, tokenDecimalsStub{"USDC": 6, "XES": 18, "MKR": 18}, "ETH")
+
+		returnMap := sync.Map{}
+		returnMap.Store("ETH", big.NewInt(1000000000000000000))
+		returnMap.Store("USDC", big.NewInt(2500000))
+		returnMap.Store("XES", big.NewInt(1000000000000000000))
+		returnMap.Store("MKR", big.NewInt(0))
+		ctx := context.WithValue(context.Background(), "returnMap", returnMap)
+
+		taxReporterBalances, err := taxReporter.GetBalances(ctx, "0x1")
+
+		if err != nil {
+			t.Fatal(err)
+		}
+		if taxReporterBalances.Balances["ETH"].String() != "1" {
+			t.Errorf("expected ETH to be %s but got %s", "1", taxReporterBalances.Balances["ETH"])
+		}
+		if taxReporterBalances.Balances["USDC"].String() != "2.5" {
+			t.Errorf("expected USDC to be %s but got %s", "2.5", taxReporterBalances.Balances["USDC"])
+		}
+		if taxReporterBalances.Balances["XES"].String() != "1" {
+			t.Errorf("expected XES to be %s but got %s", "1", taxReporterBalances.Balances["XES"])
+		}
+		if taxReporterBalances.Balances["MKR"].String() != "0" {
+			t.Errorf("expected MKR to be 0 but got %s", taxReporterBalances.Balances["MKR"])
+		}
+	})
+
+	t.Run("ShouldUseTokenDecimalsOfZeroBalances", func(t *testing.T) {
+		taxReporter := NewEthereumBalanceService(ethBalanceStub, nil, tokenDecimalsStub{"USDC": 6}, "ETH")
+
+		returnMap := sync.Map{}
+		returnMap.Store("USDC", big.NewInt(0))
+		ctx := context.WithValue(context.Background(), "returnMap", returnMap)
+
+		taxReporterBalances, err := taxReporter.GetBalances(ctx, "0x1")
+
+		if err != nil {
+			t.Fatal(err)
+		}
+		if taxReporterBalances.Balances["USDC"].Decimals != 6 {
+			t.Errorf("expected USDC decimals to be %d but got %d", 6, taxReporterBalances.Balances["USDC"].Decimals)
+		}
+		if taxReporterBalances.Balances["USDC"].String() != "0" {
+			t.Errorf("expected USDC to be 0 but got %s", taxReporterBalances.Balances["USDC"])
+		}
+	})
+
+	t.Run("ShouldReturnNativeAssetUnderNativeSymbol", func(t *testing.T) {
+		taxReporter := NewEthereumBalanceService(ethBalanceStub, nil, nil, "MATIC")
+
+		returnMap := sync.Map{}
+		returnMap.Store(NativeAssetKey, big.NewInt(1500000000000000000))
+		ctx := context.WithValue(context.Background(), "returnMap", returnMap)
+
+		taxReporterBalances, err := taxReporter.GetBalances(ctx, "0x1")
+
+		if err != nil {
+			t.Fatal(err)
+		}
+		if taxReporterBalances.Balances["MATIC"].String() != "1.5" {
+			t.Errorf("expected MATIC to be %s but got %s", "1.5", taxReporterBalances.Balances["MATIC"])
+		}
+		if taxReporterBalances.Balances["ETH"] != nil {
+			t.Error("Expected ETH to be nil")
+		}
+	})
+
+	t.Run("ShouldValueBalancesWithKnownPrices", func(t *testing.T) {
+		ethPrice := &TokenPrice{Rate: big.NewRat(181234, 100), Currency: "CHF", Timestamp: time.Unix(1577836800, 0)}
+		taxReporter := NewValuedEthereumBalanceService(ethBalanceStub, nil, nil, priceOracleStub{"ETH/CHF": ethPrice}, "CHF", "ETH")
+
+		returnMap := sync.Map{}
+		returnMap.Store(NativeAssetKey, big.NewInt(1500000000000000000))
+		returnMap.Store("XES", big.NewInt(1000000000000000000))
+		ctx := context.WithValue(context.Background(), "returnMap", returnMap)
+
+		taxReporterBalances, err := taxReporter.GetBalances(ctx, "0x1")
+
+		if err != nil {
+			t.Fatal(err)
+		}
+		if taxReporterBalances.Balances["ETH"].Price != ethPrice {
+			t.Errorf("expected ETH price to be %v but got %v", ethPrice, taxReporterBalances.Balances["ETH"].Price)
+		}
+		if value := taxReporterBalances.Balances["ETH"].Price.Value(taxReporterBalances.Balances["ETH"]).String(); value != "2718.51" {
+			t.Errorf("expected ETH value to be %s but got %s", "2718.51", value)
+		}
+		if taxReporterBalances.Balances["XES"].Price != nil {
+			t.Error("Expected XES price to be nil")
+		}
+	})
+
+	t.Run("ShouldValueBalancesWithReturnedPrices", func(t *testing.T) {
+		ethPrice := &TokenPrice{Rate: big.NewRat(181234, 100), Currency: "USD", Timestamp: time.Unix(1577836800, 0)}
+		xesPrice := &TokenPrice{Rate: big.NewRat(42, 10000), Currency: "CHF", Timestamp: time.Unix(1577836800, 0)}
+
+		returnMap := sync.Map{}
+		returnMap.Store(NativeAssetKey, big.NewInt(1500000000000000000))
+		returnMap.Store("XES", big.NewInt(1000000000000000000))
+		ctx := context.WithValue(context.Background(), "returnMap", returnMap)
+		ctx = context.WithValue(ctx, "returnPrices", map[string]*TokenPrice{NativeAssetKey: ethPrice, "XES": xesPrice})
+
+		taxReporter := NewValuedEthereumBalanceService(ethBalanceStub, nil, nil, nil, "USD", "ETH")
+		taxReporterBalances, err := taxReporter.GetBalances(ctx, "0x1")
+
+		if err != nil {
+			t.Fatal(err)
+		}
+		if taxReporterBalances.Balances["ETH"].Price != ethPrice {
+			t.Errorf("expected ETH price to be %v but got %v", ethPrice, taxReporterBalances.Balances["ETH"].Price)
+		}
+		if taxReporterBalances.Balances["XES"].Price != nil {
+			t.Error("Expected XES price in another currency to be nil")
+		}
+
+		// Without valuation the returned prices are ignored
+		taxReporterBalances, err = NewEthereumBalanceService(ethBalanceStub, nil, nil, "ETH").GetBalances(ctx, "0x1")
+
+		if err != nil {
+			t.Fatal(err)
+		}
+		if taxReporterBalances.Balances["ETH"].Price != nil {
+			t.Error("Expected ETH price to be nil")
+		}
+	})
+
+	t.Run("ShouldReturnErrorWithUnknownDecimals", func(t *testing.T) {
+		taxReporter := NewEthereumBalanceService(ethBalanceStub, nil, tokenDecimalsStub{}, "ETH")
+
+		returnMap := sync.Map{}
+		returnMap.Store("USDC", big.NewInt(2500000))
+		ctx := context.WithValue(context.Background(), "returnMap", returnMap)
+
+		_, err := taxReporter.GetBalances(ctx, "0x1")
+
+		if err == nil {
+			t.Error("Expected err but was nil")
+		}
+	})
+
 	t.Run("ShouldReturnError", func(t *testing.T) {
 		expectedError := errors.New("eth error")
 		ctx := context.WithValue(context.Background(), "returnErr", expectedError)
@@ -57,16 +200,116 @@ func TestDefaultTaxReporterService_GetBalances(t *testing.T) {
 	})
 }
 
-func TestConvertToDefaultUnit(t *testing.T) {
-	taxReporter := NewEthereumBalanceService(nil)
-	t.Run("ShouldConvertUnit", func(t *testing.T) {
-		res := taxReporter.convertToDefaultUnit(big.NewInt(10000000000000000))
-		if res.Cmp(big.NewFloat(0.01)) != 0 {
-			t.Error("err 1")
+func TestDefaultTaxReporterService_GetBalancesAtDate(t *testing.T) {
+	t.Run("ShouldReturnETHBalance", func(t *testing.T) {
+		taxReporter := NewEthereumBalanceService(&ethBalanceStub{}, NewBlockResolver(NewEthClientStub()), nil, "ETH")
+
+		returnMap := sync.Map{}
+		returnMap.Store("ETH", big.NewInt(1000000000000000000))
+		returnBlock := &BalancesBlock{Number: big.NewInt(100), Confirmed: true}
+		ctx := context.WithValue(context.Background(), "returnMap", returnMap)
+		ctx = context.WithValue(ctx, "returnBlock", returnBlock)
+
+		taxReporterBalances, err := taxReporter.GetBalancesAtDate(ctx, "0x1", time.Unix(stubGenesisTime+100*stubBlockTime, 0))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+		if taxReporterBalances.Block != returnBlock {
+			t.Errorf("expected block to be %v but got %v", returnBlock, taxReporterBalances.Block)
 		}
+		if taxReporterBalances.Balances["ETH"].Float().Cmp(big.NewFloat(1)) != 0 {
+			t.Errorf("expected ETH to be %s but got %s", "1", taxReporterBalances.Balances["ETH"])
+		}
+	})
 
-		if res.String() != "0.01" {
-			t.Error("err 2")
+	t.Run("ShouldValueBalancesAtDate", func(t *testing.T) {
+		date := time.Unix(stubGenesisTime+100*stubBlockTime, 0)
+		ethPrice := &TokenPrice{Rate: big.NewRat(2, 1), Currency: "CHF", Timestamp: date.Add(-time.Hour)}
+		xesPrice := &TokenPrice{Rate: big.NewRat(1, 2), Currency: "CHF", Timestamp: date.Add(time.Hour)}
+		priceOracle := priceOracleStub{"ETH/CHF": ethPrice, "XES/CHF": xesPrice}
+		taxReporter := NewValuedEthereumBalanceService(&ethBalanceStub{}, NewBlockResolver(NewEthClientStub()), nil, priceOracle, "CHF", "ETH")
+
+		returnMap := sync.Map{}
+		returnMap.Store("ETH", big.NewInt(1000000000000000000))
+		returnMap.Store("XES", big.NewInt(1000000000000000000))
+		ctx := context.WithValue(context.Background(), "returnMap", returnMap)
+
+		taxReporterBalances, err := taxReporter.GetBalancesAtDate(ctx, "0x1", date)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+		if taxReporterBalances.Balances["ETH"].Price != ethPrice {
+			t.Errorf("expected ETH price to be %v but got %v", ethPrice, taxReporterBalances.Balances["ETH"].Price)
+		}
+		// The price of XES is only known after the date
+		if taxReporterBalances.Balances["XES"].Price != nil {
+			t.Errorf("expected XES price to be nil but got %v", taxReporterBalances.Balances["XES"].Price)
+		}
+	})
+
+	t.Run("ShouldReturnErrorWithoutBlockResolver", func(t *testing.T) {
+		taxReporter := NewEthereumBalanceService(&ethBalanceStub{}, nil, nil, "ETH")
+		_, err := taxReporter.GetBalancesAtDate(context.Background(), "0x1", time.Now())
+
+		if err != errHistoricalBalancesNotSupported {
+			t.Errorf("expected %v but got %v", errHistoricalBalancesNotSupported, err)
+		}
+	})
+}
+
+func TestDefaultTaxReporterService_GetBalancesAtBlock(t *testing.T) {
+	taxReporter := NewEthereumBalanceService(&ethBalanceStub{}, nil, nil, "ETH")
+
+	returnMap := sync.Map{}
+	returnMap.Store("ETH", big.NewInt(1000000000000000000))
+	returnBlock := &BalancesBlock{Number: big.NewInt(100), Confirmed: true}
+	ctx := context.WithValue(context.Background(), "returnMap", returnMap)
+	ctx = context.WithValue(ctx, "returnBlock", returnBlock)
+
+	taxReporterBalances, err := taxReporter.GetBalancesAtBlock(ctx, "0x1", big.NewInt(100))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if taxReporterBalances.Block != returnBlock {
+		t.Errorf("expected block to be %v but got %v", returnBlock, taxReporterBalances.Block)
+	}
+	if taxReporterBalances.Balances["ETH"].String() != "1" {
+		t.Errorf("expected ETH to be %s but got %s", "1", taxReporterBalances.Balances["ETH"])
+	}
+}
+
+func TestDefaultTaxReporterService_GetBalancesAtBlockValued(t *testing.T) {
+	blockTime := time.Unix(stubGenesisTime+100*stubBlockTime, 0)
+	ethPrice := &TokenPrice{Rate: big.NewRat(2, 1), Currency: "CHF", Timestamp: blockTime}
+	taxReporter := NewValuedEthereumBalanceService(&ethBalanceStub{}, nil, nil, priceOracleStub{"ETH/CHF": ethPrice}, "CHF", "ETH")
+
+	returnMap := sync.Map{}
+	returnMap.Store("ETH", big.NewInt(1000000000000000000))
+	ctx := context.WithValue(context.Background(), "returnMap", returnMap)
+
+	t.Run("ShouldValueBalancesAtBlockTime", func(t *testing.T) {
+		ctx := context.WithValue(ctx, "returnBlock", &BalancesBlock{Number: big.NewInt(100), Time: blockTime})
+		taxReporterBalances, err := taxReporter.GetBalancesAtBlock(ctx, "0x1", big.NewInt(100))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+		if taxReporterBalances.Balances["ETH"].Price != ethPrice {
+			t.Errorf("expected ETH price to be %v but got %v", ethPrice, taxReporterBalances.Balances["ETH"].Price)
+		}
+	})
+
+	t.Run("ShouldNotValueBalancesAtUnknownTime", func(t *testing.T) {
+		taxReporterBalances, err := taxReporter.GetBalancesAtBlock(ctx, "0x1", big.NewInt(100))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+		if taxReporterBalances.Balances["ETH"].Price != nil {
+			t.Errorf("expected ETH price to be nil but got %v", taxReporterBalances.Balances["ETH"].Price)
 		}
 	})
 }
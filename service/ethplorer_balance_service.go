@@ -3,52 +3,242 @@ package service
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"math/big"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
 )
 
-type ethplorerBalanceService struct {
-	smartContractTokensMap map[string]string
-	apiKey                 string
-}
+type (
+	// Settings of the Ethplorer API, empty fields take their default value
+	EthplorerConfig struct {
+		BaseURL string
+		APIKey  string
+		// Ignored if HTTPClient is set
+		Timeout    time.Duration
+		HTTPClient *http.Client
+	}
+
+	// Error returned by the Ethplorer API. It wraps ErrEthplorerInvalidAddress, ErrEthplorerRateLimit or
+	// ErrEthplorerInvalidAPIKey when it's one of them, to be matched with errors.Is.
+	EthplorerError struct {
+		StatusCode int
+		Code       int
+		Message    string
+		kind       error
+	}
+
+	ethplorerBalanceService struct {
+		smartContractTokensMap map[string]string
+		baseURL                string
+		apiKey                 string
+		httpClient             *http.Client
+		// Symbols of smartContractTokensMap by checksummed contract address, Ethplorer returns lowercase addresses
+		tokensByAddress map[string]string
+		// Decimals of the tokens, configured or returned by Ethplorer along with the balances
+		decimals     map[string]uint8
+		decimalsLock sync.RWMutex
+	}
+)
+
+const (
+	DefaultEthplorerBaseURL = "https://api.ethplorer.io"
+	// Public key of Ethplorer, limited to a few requests per second
+	DefaultEthplorerAPIKey  = "freekey"
+	DefaultEthplorerTimeout = time.Second * 30
+	// Currency of the prices returned by Ethplorer
+	EthplorerCurrency = "USD"
+
+	// Codes of the "error" object returned by Ethplorer
+	ethplorerInvalidAPIKeyCode  = 1
+	ethplorerInvalidAddressCode = 104
+)
+
+var (
+	ErrEthplorerInvalidAddress = errors.New("invalid address")
+	ErrEthplorerRateLimit      = errors.New("Ethplorer rate limit exceeded")
+	ErrEthplorerInvalidAPIKey  = errors.New("invalid Ethplorer API key")
+)
+
+// configuredDecimals take precedence over the decimals returned by Ethplorer. It can be nil.
+func NewEthplorerBalanceService(config EthplorerConfig, smartContractTokensMap map[string]string, configuredDecimals map[string]uint8) *ethplorerBalanceService {
+	decimals := make(map[string]uint8, len(configuredDecimals))
+	for symbol, tokenDecimals := range configuredDecimals {
+		decimals[symbol] = tokenDecimals
+	}
+
+	tokensByAddress := make(map[string]string, len(smartContractTokensMap))
+	for contract, symbol := range smartContractTokensMap {
+		tokensByAddress[common.HexToAddress(contract).Hex()] = symbol
+	}
+
+	if len(config.BaseURL) == 0 {
+		config.BaseURL = DefaultEthplorerBaseURL
+	}
+	if len(config.APIKey) == 0 {
+		config.APIKey = DefaultEthplorerAPIKey
+	}
+	if config.HTTPClient == nil {
+		if config.Timeout == 0 {
+			config.Timeout = DefaultEthplorerTimeout
+		}
+		config.HTTPClient = &http.Client{Timeout: config.Timeout}
+	}
 
-func NewEthplorerBalanceService(smartContractTokensMap map[string]string) *ethplorerBalanceService {
-	return &ethplorerBalanceService{smartContractTokensMap: smartContractTokensMap, apiKey: "freekey"}
+	return &ethplorerBalanceService{
+		smartContractTokensMap: smartContractTokensMap,
+		tokensByAddress:        tokensByAddress,
+		baseURL:                strings.TrimSuffix(config.BaseURL, "/"),
+		apiKey:                 config.APIKey,
+		httpClient:             config.HTTPClient,
+		decimals:               decimals,
+	}
 }
 
 func (me *ethplorerBalanceService) GetBalancesForAddress(ctx context.Context, address string) (*sync.Map, error) {
-	resp, err := http.Get("http://api.ethplorer.io/getAddressInfo/" + address + "?apiKey=" + me.apiKey)
+	result, err := me.GetBalancesForAddressAtBlock(ctx, address, nil) // Last block
 	if err != nil {
 		return nil, err
 	}
+	return result.Balances, nil
+}
+
+// Requests path from the Ethplorer API and decodes the response into result
+func (me *ethplorerBalanceService) get(ctx context.Context, path string, result ethplorerResult) error {
+	requestURL := me.baseURL + path + "?apiKey=" + url.QueryEscape(me.apiKey)
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, requestURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := me.httpClient.Do(request)
+	if err != nil {
+		// The URL contains the API key
+		if urlErr, ok := err.(*url.Error); ok {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("requesting Ethplorer. error: %v", err)
+	}
 
 	defer resp.Body.Close()
+	decodeErr := json.NewDecoder(resp.Body).Decode(result)
+	if apiError := result.apiError(); apiError != nil || resp.StatusCode != http.StatusOK {
+		return newEthplorerError(resp.StatusCode, apiError)
+	}
+	if decodeErr != nil {
+		return fmt.Errorf("decoding Ethplorer response. error: %v", decodeErr)
+	}
+
+	return nil
+}
+
+// apiError is nil if the response has no "error" object
+func newEthplorerError(statusCode int, apiError *ethplorerError) *EthplorerError {
+	err := &EthplorerError{StatusCode: statusCode}
+	if apiError != nil {
+		err.Code = apiError.Code
+		err.Message = apiError.Message
+	}
+
+	switch {
+	case statusCode == http.StatusTooManyRequests:
+		err.kind = ErrEthplorerRateLimit
+	case err.Code == ethplorerInvalidAPIKeyCode || statusCode == http.StatusUnauthorized:
+		err.kind = ErrEthplorerInvalidAPIKey
+	case err.Code == ethplorerInvalidAddressCode:
+		err.kind = ErrEthplorerInvalidAddress
+	}
+	return err
+}
+
+func (me *EthplorerError) Error() string {
+	if len(me.Message) == 0 {
+		return fmt.Sprintf("Ethplorer answered with status %d", me.StatusCode)
+	}
+	return fmt.Sprintf("Ethplorer error %d: %s", me.Code, me.Message)
+}
+
+func (me *EthplorerError) Unwrap() error {
+	return me.kind
+}
+
+// Ethplorer only exposes current balances. A nil blockNumber is accepted and means the last block.
+func (me *ethplorerBalanceService) GetBalancesForAddressAtBlock(ctx context.Context, address string, blockNumber *big.Int) (*BlockBalances, error) {
+	if blockNumber != nil {
+		return nil, errHistoricalBalancesNotSupported
+	}
+
 	ethplorerResp := ethplorerResponse{}
-	err = json.NewDecoder(resp.Body).Decode(&ethplorerResp)
+	err := me.get(ctx, "/getAddressInfo/"+url.PathEscape(address), &ethplorerResp)
 	if err != nil {
 		return nil, err
 	}
 
-	balances := me.toMap(ethplorerResp)
+	return me.toBlockBalances(ethplorerResp)
+}
+
+// Knows the decimals of configured tokens and of the tokens returned by a previous balances request. The decimals of
+// the other tokens, e.g. tokens the address doesn't hold, are requested from Ethplorer.
+func (me *ethplorerBalanceService) TokenDecimals(ctx context.Context, symbol string) (uint8, error) {
+	tokenDecimals, ok := me.knownDecimals(symbol)
+	if ok {
+		return tokenDecimals, nil
+	}
+
+	for contract, contractSymbol := range me.tokensByAddress {
+		if contractSymbol != symbol {
+			continue
+		}
+		info := ethplorerTokenInfoResponse{}
+		err := me.get(ctx, "/getTokenInfo/"+url.PathEscape(contract), &info)
+		if err != nil {
+			return 0, fmt.Errorf("retrieving %s decimals. error: %v", symbol, err)
+		}
+		me.storeDecimals(symbol, info.tokenInfo)
+		break
+	}
 
-	return balances, nil
+	tokenDecimals, ok = me.knownDecimals(symbol)
+	if !ok {
+		return 0, errUnknownTokenDecimals
+	}
+	return tokenDecimals, nil
 }
 
-func (me *ethplorerBalanceService) toMap(resp ethplorerResponse) *sync.Map {
+func (me *ethplorerBalanceService) knownDecimals(symbol string) (uint8, bool) {
+	me.decimalsLock.RLock()
+	defer me.decimalsLock.RUnlock()
+
+	tokenDecimals, ok := me.decimals[symbol]
+	return tokenDecimals, ok
+}
+
+// Ethplorer doesn't tell at which block the balances were calculated. The balances come with the current prices of
+// the tokens Ethplorer knows, in US dollars.
+func (me *ethplorerBalanceService) toBlockBalances(resp ethplorerResponse) (*BlockBalances, error) {
 	balances := new(sync.Map)
-	ethFloat := big.NewFloat(resp.ETH.Balance)
-	ethFloat.Mul(ethFloat, big.NewFloat(1000000000000000000))
-	log.Println("Total?", ethFloat.String())
-	ethString := ethFloat.Text('f', 0)
-	ethBalance := big.NewInt(0)
-	ethBalance.SetString(ethString, 10)
-	balances.Store("ETH", ethBalance)
+	prices := make(map[string]*TokenPrice)
+	ethBalance, err := resp.ETH.wei()
+	if err != nil {
+		return nil, err
+	}
+	balances.Store(NativeAssetKey, ethBalance)
+	err = addEthplorerPrice(prices, NativeAssetKey, resp.ETH.Price)
+	if err != nil {
+		return nil, err
+	}
 
-	tokensMap := me.responseTokensToMap(resp.Tokens)
+	tokensMap, warnings, err := me.responseTokensToMap(resp.Tokens, prices)
+	if err != nil {
+		return nil, err
+	}
 
 	for _, tokenSymbol := range me.smartContractTokensMap {
 		balance, found := tokensMap[tokenSymbol]
@@ -60,16 +250,84 @@ func (me *ethplorerBalanceService) toMap(resp ethplorerResponse) *sync.Map {
 		}
 	}
 
-	return balances
+	return &BlockBalances{Balances: balances, Prices: prices, Warnings: warnings}, nil
 }
 
-func (me *ethplorerBalanceService) responseTokensToMap(tokens []token) map[string]*big.Int {
-	balances := make(map[string]*big.Int)
+// Keys the balances of the configured tokens by their symbol. Tokens are identified by their contract address, as
+// anyone can deploy a token with the symbol of another one. Such tokens are returned as warnings. The prices of the
+// configured tokens are added to prices.
+func (me *ethplorerBalanceService) responseTokensToMap(tokens []token, prices map[string]*TokenPrice) (map[string]*big.Int, []string, error) {
+	configuredSymbols := make(map[string]bool, len(me.tokensByAddress))
+	for _, symbol := range me.tokensByAddress {
+		configuredSymbols[symbol] = true
+	}
+
+	var (
+		balances = make(map[string]*big.Int)
+		warnings []string
+	)
 	for _, token := range tokens {
-		balances[token.Symbol] = token.Balance.BigIntValue
+		symbol, found := "", false
+		if common.IsHexAddress(token.Address) {
+			symbol, found = me.tokensByAddress[common.HexToAddress(token.Address).Hex()]
+		}
+		if !found {
+			if configuredSymbols[token.Symbol] {
+				warning := fmt.Sprintf("ignored token %s at %s, it's not the contract of the configured %s token", token.Symbol, token.Address, token.Symbol)
+				log.Printf("[taxreporter][balances] %s", warning)
+				warnings = append(warnings, warning)
+			}
+			continue
+		}
+
+		balance := token.Balance.BigIntValue
+		// rawBalance is the exact balance, balance may be rounded when it's written in scientific notation
+		if len(token.RawBalance) != 0 {
+			var ok bool
+			balance, ok = new(big.Int).SetString(token.RawBalance, 10)
+			if !ok {
+				return nil, nil, fmt.Errorf("invalid rawBalance %q of token %s", token.RawBalance, symbol)
+			}
+		}
+		balances[symbol] = balance
+		me.storeDecimals(symbol, token.tokenInfo)
+		err := addEthplorerPrice(prices, symbol, token.Price)
+		if err != nil {
+			return nil, nil, err
+		}
 	}
 
-	return balances
+	return balances, warnings, nil
+}
+
+// Tokens without price aren't added
+func addEthplorerPrice(prices map[string]*TokenPrice, symbol string, price ethplorerPrice) error {
+	tokenPrice, err := price.tokenPrice()
+	if err != nil {
+		return fmt.Errorf("%s: %v", symbol, err)
+	}
+
+	if tokenPrice != nil {
+		prices[symbol] = tokenPrice
+	}
+	return nil
+}
+
+func (me *ethplorerBalanceService) storeDecimals(symbol string, info tokenInfo) {
+	if len(info.Decimals) == 0 {
+		return
+	}
+	tokenDecimals, err := strconv.ParseUint(info.Decimals.String(), 10, 8)
+	if err != nil {
+		log.Printf("Invalid decimals %s of token %s: %v", info.Decimals, symbol, err)
+		return
+	}
+
+	me.decimalsLock.Lock()
+	defer me.decimalsLock.Unlock()
+	if _, configured := me.decimals[symbol]; !configured {
+		me.decimals[symbol] = uint8(tokenDecimals)
+	}
 }
 
 type BigInt struct {
@@ -87,16 +345,13 @@ func (b *BigInt) UnmarshalJSON(p []byte) error {
 	}
 	b.BigIntValue = new(big.Int)
 
-	if strings.Contains(stringValue, "e") {
-		// Scientific notation
-		var newNum float64
-		_, err := fmt.Sscanf(stringValue, "%e", &newNum)
+	// Large balances are written in scientific notation, e.g. 5.483e+21
+	if strings.ContainsAny(stringValue, "eE.") {
+		amount, err := ParseTokenAmount(stringValue, 0, RoundHalfUp)
 		if err != nil {
-			log.Printf("Can't convert %s, error: %v", stringValue, err)
-			return err
+			return fmt.Errorf("not a valid big integer: %v", err)
 		}
-
-		b.BigIntValue.SetString(fmt.Sprintf("%.f", newNum), 10)
+		b.BigIntValue = amount.Value
 		return nil
 	}
 
@@ -109,41 +364,127 @@ func (b *BigInt) UnmarshalJSON(p []byte) error {
 }
 
 type ethplorerResponse struct {
-	Address  string     `json:"address"`
-	ETH      ethBalance `json:"ETH"`
-	CountTxs int        `json:"countTxs"`
-	Tokens   []token    `json:"tokens"`
+	Error    *ethplorerError `json:"error"`
+	Address  string          `json:"address"`
+	ETH      ethBalance      `json:"ETH"`
+	CountTxs int             `json:"countTxs"`
+	Tokens   []token         `json:"tokens"`
+}
+
+// Response of getTokenInfo
+type ethplorerTokenInfoResponse struct {
+	tokenInfo
+	Error *ethplorerError `json:"error"`
+}
+
+// Ethplorer response, with the "error" object set if the request failed
+type ethplorerResult interface {
+	apiError() *ethplorerError
+}
+
+func (me *ethplorerResponse) apiError() *ethplorerError {
+	return me.Error
+}
+
+func (me *ethplorerTokenInfoResponse) apiError() *ethplorerError {
+	return me.Error
+}
+
+type ethplorerError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
 }
 
 type token struct {
-	tokenInfo `json:"tokenInfo,omitempty"`
-	Balance   BigInt `json:"balance"`
-	TotalIn   int    `json:"totalIn"`
-	TotalOut  int    `json:"totalOut"`
+	tokenInfo  `json:"tokenInfo,omitempty"`
+	Balance    BigInt `json:"balance"`
+	RawBalance string `json:"rawBalance"`
+	TotalIn    int    `json:"totalIn"`
+	TotalOut   int    `json:"totalOut"`
 }
 
 type ethBalance struct {
-	Balance float64 `json:"balance"`
-	Price   struct {
-		Rate            float64 `json:"rate"`
-		Diff            float64 `json:"diff"`
-		Diff7D          float64 `json:"diff7d"`
-		Ts              int     `json:"ts"`
-		MarketCapUsd    float64 `json:"marketCapUsd"`
-		AvailableSupply float64 `json:"availableSupply"`
-		Volume24H       float64 `json:"volume24h"`
-		Diff30D         float64 `json:"diff30d"`
-	} `json:"price"`
+	// In ether, kept as written in the response to be parsed without loss of precision
+	Balance json.Number `json:"balance"`
+	// Exact balance in wei, not returned by every version of the API
+	RawBalance string         `json:"rawBalance"`
+	Price      ethplorerPrice `json:"price"`
+}
+
+// Ethplorer returns false instead of an object for tokens without price
+type ethplorerPrice struct {
+	// Kept as written in the response to be parsed without loss of precision
+	Rate            json.Number `json:"rate"`
+	Currency        string      `json:"currency"`
+	Diff            float64     `json:"diff"`
+	Diff7D          float64     `json:"diff7d"`
+	Ts              int64       `json:"ts"`
+	MarketCapUsd    float64     `json:"marketCapUsd"`
+	AvailableSupply float64     `json:"availableSupply"`
+	Volume24H       float64     `json:"volume24h"`
+	Diff30D         float64     `json:"diff30d"`
+}
+
+func (me *ethplorerPrice) UnmarshalJSON(p []byte) error {
+	if string(p) == "false" || string(p) == "null" {
+		*me = ethplorerPrice{}
+		return nil
+	}
+
+	// Without the methods of ethplorerPrice, to not call UnmarshalJSON recursively
+	type price ethplorerPrice
+	return json.Unmarshal(p, (*price)(me))
+}
+
+// Nil if the response has no price
+func (me ethplorerPrice) tokenPrice() (*TokenPrice, error) {
+	if len(me.Rate) == 0 {
+		return nil, nil
+	}
+	rate, ok := parseRate(me.Rate.String())
+	if !ok {
+		return nil, fmt.Errorf("invalid price rate %q", me.Rate)
+	}
+
+	// The currency isn't returned for ETH
+	currency := me.Currency
+	if len(currency) == 0 {
+		currency = EthplorerCurrency
+	}
+	return &TokenPrice{Rate: rate, Currency: currency, Timestamp: time.Unix(me.Ts, 0).UTC()}, nil
+}
+
+// Exact balance in wei, from rawBalance if set
+func (me ethBalance) wei() (*big.Int, error) {
+	if len(me.RawBalance) != 0 {
+		wei, ok := new(big.Int).SetString(me.RawBalance, 10)
+		if !ok {
+			return nil, fmt.Errorf("invalid ETH rawBalance %q", me.RawBalance)
+		}
+		return wei, nil
+	}
+	if len(me.Balance) == 0 {
+		return big.NewInt(0), nil
+	}
+
+	// Digits beyond wei can only be artefacts of a floating point balance
+	amount, err := ParseTokenAmount(me.Balance.String(), ethDecimals, RoundHalfUp)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ETH balance: %v", err)
+	}
+	return amount.Value, nil
 }
 
 type tokenInfo struct {
-	Address           string `json:"address"`
-	Name              string `json:"name"`
-	Symbol            string `json:"symbol"`
-	TotalSupply       string `json:"totalSupply"`
-	Owner             string `json:"owner"`
-	LastUpdated       int    `json:"lastUpdated"`
-	IssuancesCount    int    `json:"issuancesCount"`
-	HoldersCount      int    `json:"holdersCount"`
-	EthTransfersCount int    `json:"ethTransfersCount"`
+	Address           string         `json:"address"`
+	Name              string         `json:"name"`
+	Symbol            string         `json:"symbol"`
+	Decimals          json.Number    `json:"decimals"` // Either a number or a string
+	TotalSupply       string         `json:"totalSupply"`
+	Owner             string         `json:"owner"`
+	LastUpdated       int            `json:"lastUpdated"`
+	IssuancesCount    int            `json:"issuancesCount"`
+	HoldersCount      int            `json:"holdersCount"`
+	EthTransfersCount int            `json:"ethTransfersCount"`
+	Price             ethplorerPrice `json:"price"`
 }
@@ -1,8 +1,14 @@
 package service
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"math/big"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -13,21 +19,34 @@ func TestGetBalancesForAddress(t *testing.T) {
 	json := ethplorerResponse{
 		Address: "",
 		ETH: ethBalance{
-			Balance: 5.05,
+			Balance: "5.05",
 		},
 		CountTxs: 0,
 		Tokens: []token{
 			{
 				tokenInfo: tokenInfo{
-					Symbol: "XES",
+					Address:  "0x84e0b37e8f5b4b86d5d299b0b0e33686405a3919",
+					Symbol:   "XES",
+					Decimals: "18",
 				},
 				Balance:  BigInt{BigIntValue: balanceXES},
 				TotalIn:  0,
 				TotalOut: 0,
 			},
+			// Impersonates XES, it must not overwrite its balance nor decimals
 			{
 				tokenInfo: tokenInfo{
-					Symbol: "MKR",
+					Address:  "0x0000000000000000000000000000000000000bad",
+					Symbol:   "XES",
+					Decimals: "0",
+				},
+				Balance: BigInt{BigIntValue: big.NewInt(1000000)},
+			},
+			{
+				tokenInfo: tokenInfo{
+					Address:  "0x710129558e8fff5cab9c0c9c43b99d79ed864b99",
+					Symbol:   "MKR",
+					Decimals: "6",
 				},
 				Balance:  BigInt{BigIntValue: big.NewInt(7373767001504)},
 				TotalIn:  0,
@@ -41,15 +60,200 @@ func TestGetBalancesForAddress(t *testing.T) {
 		"0x710129558E8ffF5caB9c0c9c43b99d79Ed864B99": "MKR",
 		"0x123456558E8ffF5caB9c0c9c43b99d79Ed864B99": "ANY",
 	}
-	balanceService := NewEthplorerBalanceService(tokensMap)
-	balances := balanceService.toMap(json)
+	balanceService := NewEthplorerBalanceService(EthplorerConfig{}, tokensMap, map[string]uint8{"MKR": 18})
+	result, err := balanceService.toBlockBalances(json)
+	assert.Nil(t, err)
+	assert.Nil(t, result.Block)
 
-	xesBalance, _ := balances.Load("XES")
-	mkrBalance, _ := balances.Load("MKR")
-	anyBalance, _ := balances.Load("ANY")
+	xesBalance, _ := result.Balances.Load("XES")
+	mkrBalance, _ := result.Balances.Load("MKR")
+	anyBalance, _ := result.Balances.Load("ANY")
 	expectedXESBalance := big.NewInt(0)
 	expectedXESBalance.SetString("5483000000000000000000", 10)
 	assert.Equal(t, expectedXESBalance, xesBalance)
 	assert.Equal(t, big.NewInt(7373767001504), mkrBalance)
 	assert.Equal(t, big.NewInt(0), anyBalance)
+	assert.Equal(t, []string{"ignored token XES at 0x0000000000000000000000000000000000000bad, it's not the contract of the configured XES token"}, result.Warnings)
+
+	xesDecimals, err := balanceService.TokenDecimals(context.Background(), "XES")
+	assert.Nil(t, err)
+	assert.Equal(t, uint8(18), xesDecimals)
+	// Configured decimals take precedence
+	mkrDecimals, err := balanceService.TokenDecimals(context.Background(), "MKR")
+	assert.Nil(t, err)
+	assert.Equal(t, uint8(18), mkrDecimals)
+}
+
+func TestEthplorerBalanceService_GetBalancesForAddress(t *testing.T) {
+	var requestedURL string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestedURL = r.URL.String()
+		switch r.URL.Path {
+		case "/getAddressInfo/0x043129ab3945D2bB75f3B5DE21487343EFBeffd2":
+			w.Write([]byte(`{"address": "0x043129ab3945d2bb75f3b5de21487343efbeffd2", "ETH": {"balance": 0.5}, "tokens": [{"tokenInfo": {"address": "0xa017ac5fac5941f95010b12570b812c974469c2c", "symbol": "XES", "decimals": "18"}, "balance": 5483000000000000000000}]}`))
+		case "/getAddressInfo/0x1234":
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error": {"code": 104, "message": "Invalid address format"}}`))
+		case "/getAddressInfo/0xbadkey":
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error": {"code": 1, "message": "Invalid API key"}}`))
+		case "/getAddressInfo/0xlimit":
+			w.WriteHeader(http.StatusTooManyRequests)
+		case "/getAddressInfo/0xslow":
+			<-r.Context().Done()
+		case "/getTokenInfo/0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48":
+			w.Write([]byte(`{"address": "0xa0b86991c6218b36c1d19d4a2e9eb0ce3606eb48", "symbol": "USDC", "decimals": "6"}`))
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	tokensMap := map[string]string{
+		"0xA017ac5faC5941f95010b12570B812C974469c2C": "XES",
+		"0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48": "USDC",
+		"0x123456558E8ffF5caB9c0c9c43b99d79Ed864B99": "ANY",
+	}
+	balanceService := NewEthplorerBalanceService(EthplorerConfig{BaseURL: server.URL + "/", APIKey: "my key"}, tokensMap, nil)
+
+	t.Run("ShouldRetrieveBalances", func(t *testing.T) {
+		balances, err := balanceService.GetBalancesForAddress(context.Background(), "0x043129ab3945D2bB75f3B5DE21487343EFBeffd2")
+		assert.Nil(t, err)
+		assert.Equal(t, "/getAddressInfo/0x043129ab3945D2bB75f3B5DE21487343EFBeffd2?apiKey=my+key", requestedURL)
+
+		xesBalance, _ := balances.Load("XES")
+		expectedXESBalance, _ := new(big.Int).SetString("5483000000000000000000", 10)
+		assert.Equal(t, expectedXESBalance, xesBalance)
+	})
+
+	t.Run("ShouldRequestDecimalsOfTokensNotHeld", func(t *testing.T) {
+		balances, err := balanceService.GetBalancesForAddress(context.Background(), "0x043129ab3945D2bB75f3B5DE21487343EFBeffd2")
+		assert.Nil(t, err)
+		usdcBalance, _ := balances.Load("USDC")
+		assert.Equal(t, big.NewInt(0), usdcBalance)
+
+		usdcDecimals, err := balanceService.TokenDecimals(context.Background(), "USDC")
+		assert.Nil(t, err)
+		assert.Equal(t, uint8(6), usdcDecimals)
+		assert.Equal(t, "/getTokenInfo/0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48?apiKey=my+key", requestedURL)
+
+		_, err = balanceService.TokenDecimals(context.Background(), "ANY")
+		assert.NotNil(t, err)
+		_, err = balanceService.TokenDecimals(context.Background(), "UNKNOWN")
+		assert.Equal(t, errUnknownTokenDecimals, err)
+	})
+
+	t.Run("ShouldReturnTypedErrors", func(t *testing.T) {
+		_, err := balanceService.GetBalancesForAddress(context.Background(), "0x1234")
+		assert.True(t, errors.Is(err, ErrEthplorerInvalidAddress))
+		assert.Equal(t, "Ethplorer error 104: Invalid address format", err.Error())
+
+		_, err = balanceService.GetBalancesForAddress(context.Background(), "0xbadkey")
+		assert.True(t, errors.Is(err, ErrEthplorerInvalidAPIKey))
+
+		_, err = balanceService.GetBalancesForAddress(context.Background(), "0xlimit")
+		assert.True(t, errors.Is(err, ErrEthplorerRateLimit))
+
+		_, err = balanceService.GetBalancesForAddress(context.Background(), "0xother")
+		ethplorerErr := &EthplorerError{}
+		assert.True(t, errors.As(err, &ethplorerErr))
+		assert.Equal(t, http.StatusInternalServerError, ethplorerErr.StatusCode)
+		assert.Nil(t, errors.Unwrap(err))
+	})
+
+	t.Run("ShouldStopOnCancellation", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+		defer cancel()
+		_, err := balanceService.GetBalancesForAddress(ctx, "0xslow")
+		assert.NotNil(t, err)
+		// The URL of the request contains the API key
+		assert.NotContains(t, err.Error(), "my")
+	})
+}
+
+func TestEthplorerResponse_balances(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		expected string // ETH balance in wei, empty if the response is invalid
+	}{
+		{"more digits than a float64", `{"ETH": {"balance": 1234.567890123456789}}`, "1234567890123456789000"},
+		{"raw balance", `{"ETH": {"balance": 1.2, "rawBalance": "1200000000000000001"}}`, "1200000000000000001"},
+		{"large", `{"ETH": {"balance": 98765432109876543210.123456789012345678}}`, "98765432109876543210123456789012345678"},
+		{"one wei", `{"ETH": {"balance": 1e-18}}`, "1"},
+		{"below one wei", `{"ETH": {"balance": 1.23456789012345678901234e+3}}`, "1234567890123456789012"},
+		{"no balance", `{"ETH": {}}`, "0"},
+		{"invalid raw balance", `{"ETH": {"balance": 1, "rawBalance": "1.5"}}`, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp := ethplorerResponse{}
+			assert.Nil(t, json.Unmarshal([]byte(test.response), &resp))
+			wei, err := resp.ETH.wei()
+			if len(test.expected) == 0 {
+				assert.NotNil(t, err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, test.expected, wei.String())
+		})
+	}
+
+	tokenTests := []struct {
+		name     string
+		token    string
+		expected string // Balance in base units
+	}{
+		{"scientific notation", `{"balance": 5.483e+21}`, "5483000000000000000000"},
+		{"large integer", `{"balance": 123456789012345678901234567890}`, "123456789012345678901234567890"},
+		{"raw balance", `{"balance": 1.2345678901234567e+29, "rawBalance": "123456789012345678901234567890"}`, "123456789012345678901234567890"},
+		{"tiny", `{"balance": 1}`, "1"},
+	}
+
+	tokensMap := map[string]string{"0xA017ac5faC5941f95010b12570B812C974469c2C": "XES"}
+	balanceService := NewEthplorerBalanceService(EthplorerConfig{}, tokensMap, nil)
+	for _, test := range tokenTests {
+		t.Run(test.name, func(t *testing.T) {
+			tokenBalance := token{}
+			assert.Nil(t, json.Unmarshal([]byte(test.token), &tokenBalance))
+			tokenBalance.Address = "0xa017ac5fac5941f95010b12570b812c974469c2c"
+			balances, _, err := balanceService.responseTokensToMap([]token{tokenBalance}, make(map[string]*TokenPrice))
+			assert.Nil(t, err)
+			assert.Equal(t, test.expected, balances["XES"].String())
+		})
+	}
+}
+
+func TestEthplorerBalanceService_Prices(t *testing.T) {
+	response := `{"ETH": {"balance": 1, "price": {"rate": 1812.34, "ts": 1577836800}}, "tokens": [
+		{"tokenInfo": {"address": "0xa017ac5fac5941f95010b12570b812c974469c2c", "symbol": "XES", "decimals": "18", "price": {"rate": 4.2e-3, "currency": "USD", "ts": 1577836860}}, "balance": 1},
+		{"tokenInfo": {"address": "0x710129558e8fff5cab9c0c9c43b99d79ed864b99", "symbol": "MKR", "decimals": "18", "price": false}, "balance": 1}
+	]}`
+	resp := ethplorerResponse{}
+	assert.Nil(t, json.Unmarshal([]byte(response), &resp))
+
+	tokensMap := map[string]string{
+		"0xA017ac5faC5941f95010b12570B812C974469c2C": "XES",
+		"0x710129558E8ffF5caB9c0c9c43b99d79Ed864B99": "MKR",
+	}
+	balanceService := NewEthplorerBalanceService(EthplorerConfig{}, tokensMap, nil)
+	result, err := balanceService.toBlockBalances(resp)
+	assert.Nil(t, err)
+
+	ethPrice := result.Prices[NativeAssetKey]
+	assert.Equal(t, "1812.34", ethPrice.FormatRate())
+	assert.Equal(t, "USD", ethPrice.Currency)
+	assert.Equal(t, time.Unix(1577836800, 0).UTC(), ethPrice.Timestamp)
+
+	xesPrice := result.Prices["XES"]
+	assert.Equal(t, "0.0042", xesPrice.FormatRate())
+	assert.Equal(t, time.Unix(1577836860, 0).UTC(), xesPrice.Timestamp)
+
+	_, found := result.Prices["MKR"]
+	assert.False(t, found)
+
+	resp.Tokens[0].Price = ethplorerPrice{Rate: "1/3"}
+	_, err = balanceService.toBlockBalances(resp)
+	assert.NotNil(t, err)
 }
@@ -0,0 +1,202 @@
+package service
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	HTTPPriceOracleConfig struct {
+		// URL of the price of a token, with the placeholders {token}, {currency}, {date} (YYYY-MM-DD, UTC) and
+		// {timestamp} (Unix time), e.g. "https://prices.example.com/{token}/{currency}?at={timestamp}". The current
+		// time is used for the latest price.
+		URL string
+		// Path of the rate in the JSON response, e.g. "data.rate". Nested fields and array items are separated by dots.
+		// The rate is a number or a string.
+		RatePath string
+		// Optional path of the date of the rate in the JSON response, Unix time or RFC 3339. The requested date is used
+		// if empty.
+		TimestampPath string
+		// Defaults to DefaultHTTPPriceOracleTimeout, ignored if HTTPClient is set
+		Timeout    time.Duration
+		HTTPClient *http.Client
+	}
+
+	// Generic JSON price API. A 404 status means the API has no price for the token. Past prices never change, the
+	// cacheSize most recently used ones are only requested once.
+	httpPriceOracle struct {
+		config HTTPPriceOracleConfig
+		// Request URL -> element of priceOrder
+		prices map[string]*list.Element
+		// Cached prices, most recently used first
+		priceOrder *list.List
+		cacheSize  int
+		pricesLock sync.Mutex
+	}
+
+	cachedPrice struct {
+		requestURL string
+		price      *TokenPrice
+	}
+)
+
+const (
+	DefaultHTTPPriceOracleTimeout = time.Second * 30
+	// The dates of the requests are arbitrary, e.g. the time of the requested blocks
+	httpPriceCacheSize = 4096
+)
+
+func NewHTTPPriceOracle(config HTTPPriceOracleConfig) (*httpPriceOracle, error) {
+	if len(config.URL) == 0 {
+		return nil, errors.New("missing price API URL")
+	}
+	if len(config.RatePath) == 0 {
+		return nil, errors.New("missing path of the rate in the price API response")
+	}
+	if config.HTTPClient == nil {
+		if config.Timeout == 0 {
+			config.Timeout = DefaultHTTPPriceOracleTimeout
+		}
+		config.HTTPClient = &http.Client{Timeout: config.Timeout}
+	}
+
+	return &httpPriceOracle{
+		config:     config,
+		prices:     make(map[string]*list.Element),
+		priceOrder: list.New(),
+		cacheSize:  httpPriceCacheSize,
+	}, nil
+}
+
+func (me *httpPriceOracle) Price(ctx context.Context, symbol, currency string, date time.Time) (*TokenPrice, error) {
+	requestDate := date
+	if date.IsZero() {
+		requestDate = time.Now()
+	}
+	requestURL := strings.NewReplacer(
+		"{token}", url.QueryEscape(symbol),
+		"{currency}", url.QueryEscape(currency),
+		"{date}", requestDate.UTC().Format(csvPriceDateLayout),
+		"{timestamp}", strconv.FormatInt(requestDate.Unix(), 10),
+	).Replace(me.config.URL)
+
+	// The latest price changes, it's always requested
+	if !date.IsZero() {
+		if price, ok := me.cachedPrice(requestURL); ok {
+			return price, nil
+		}
+	}
+
+	price, err := me.requestPrice(ctx, requestURL, currency, requestDate)
+	if err != nil {
+		return nil, err
+	}
+
+	if !date.IsZero() {
+		me.cachePrice(requestURL, price)
+	}
+	return price, nil
+}
+
+func (me *httpPriceOracle) cachedPrice(requestURL string) (*TokenPrice, bool) {
+	me.pricesLock.Lock()
+	defer me.pricesLock.Unlock()
+	element, ok := me.prices[requestURL]
+	if !ok {
+		return nil, false
+	}
+	me.priceOrder.MoveToFront(element)
+	return element.Value.(*cachedPrice).price, true
+}
+
+// Evicts the least recently used price once the cache is full
+func (me *httpPriceOracle) cachePrice(requestURL string, price *TokenPrice) {
+	me.pricesLock.Lock()
+	defer me.pricesLock.Unlock()
+	if element, ok := me.prices[requestURL]; ok {
+		// Requested concurrently
+		me.priceOrder.MoveToFront(element)
+		return
+	}
+	me.prices[requestURL] = me.priceOrder.PushFront(&cachedPrice{requestURL: requestURL, price: price})
+	for me.priceOrder.Len() > me.cacheSize {
+		oldest := me.priceOrder.Back()
+		me.priceOrder.Remove(oldest)
+		delete(me.prices, oldest.Value.(*cachedPrice).requestURL)
+	}
+}
+
+func (me *httpPriceOracle) requestPrice(ctx context.Context, requestURL, currency string, requestDate time.Time) (*TokenPrice, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, requestURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Accept", "application/json")
+	resp, err := me.config.HTTPClient.Do(request)
+	if err != nil {
+		// The URL may contain an API key
+		if urlErr, ok := err.(*url.Error); ok {
+			err = urlErr.Err
+		}
+		return nil, fmt.Errorf("requesting price API. error: %v", err)
+	}
+
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errUnknownTokenPrice
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("price API answered with status %d", resp.StatusCode)
+	}
+
+	var body map[string]interface{}
+	decoder := json.NewDecoder(resp.Body)
+	decoder.UseNumber()
+	err = decoder.Decode(&body)
+	if err != nil {
+		return nil, fmt.Errorf("decoding price API response. error: %v", err)
+	}
+
+	rateValue, found := LookupPath(body, me.config.RatePath)
+	if !found || rateValue == nil {
+		return nil, errUnknownTokenPrice
+	}
+	rate, ok := parseRate(fmt.Sprint(rateValue))
+	if !ok || rate.Sign() < 0 {
+		return nil, fmt.Errorf("invalid rate %v in price API response", rateValue)
+	}
+
+	timestamp := requestDate.UTC()
+	if len(me.config.TimestampPath) != 0 {
+		timestampValue, _ := LookupPath(body, me.config.TimestampPath)
+		timestamp, err = parsePriceTimestamp(timestampValue)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timestamp %v in price API response", timestampValue)
+		}
+	}
+
+	return &TokenPrice{Rate: rate, Currency: currency, Timestamp: timestamp}, nil
+}
+
+// Unix time or RFC 3339 date
+func parsePriceTimestamp(value interface{}) (time.Time, error) {
+	switch timestamp := value.(type) {
+	case json.Number:
+		seconds, err := timestamp.Int64()
+		return time.Unix(seconds, 0).UTC(), err
+	case string:
+		parsed, err := time.Parse(time.RFC3339, timestamp)
+		return parsed.UTC(), err
+	default:
+		return time.Time{}, errors.New("missing timestamp")
+	}
+}
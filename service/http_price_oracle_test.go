@@ -0,0 +1,97 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTTPPriceOracle_Price(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch r.URL.String() {
+		case "/prices/ETH/CHF?date=2023-12-31&ts=1704067199":
+			w.Write([]byte(`{"data": {"rate": 1909.123456789012345678901, "updated": 1704063000}}`))
+		case "/prices/ETH/CHF?date=2023-12-30&ts=1703980799":
+			w.Write([]byte(`{"data": {"rate": 1890, "updated": 1703976600}}`))
+		case "/prices/XES/CHF?date=2023-12-31&ts=1704067199":
+			w.Write([]byte(`{"data": {"rate": "0.0042", "updated": "2023-12-31T22:00:00Z"}}`))
+		case "/prices/MKR/CHF?date=2023-12-31&ts=1704067199":
+			w.Write([]byte(`{"data": {"rate": null}}`))
+		case "/prices/BAT/CHF?date=2023-12-31&ts=1704067199":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	priceOracle, err := NewHTTPPriceOracle(HTTPPriceOracleConfig{
+		URL:           server.URL + "/prices/{token}/{currency}?date={date}&ts={timestamp}",
+		RatePath:      "data.rate",
+		TimestampPath: "data.updated",
+	})
+	assert.Nil(t, err)
+	date := time.Date(2023, 12, 31, 23, 59, 59, 0, time.UTC)
+
+	t.Run("ShouldReturnExactRate", func(t *testing.T) {
+		price, err := priceOracle.Price(context.Background(), "ETH", "CHF", date)
+		assert.Nil(t, err)
+		assert.Equal(t, "1909.123456789012345679", price.FormatRate())
+		assert.Equal(t, "CHF", price.Currency)
+		assert.Equal(t, time.Unix(1704063000, 0).UTC(), price.Timestamp)
+
+		price, err = priceOracle.Price(context.Background(), "XES", "CHF", date)
+		assert.Nil(t, err)
+		assert.Equal(t, "0.0042", price.FormatRate())
+		assert.Equal(t, time.Date(2023, 12, 31, 22, 0, 0, 0, time.UTC), price.Timestamp)
+	})
+
+	t.Run("ShouldCachePastPrices", func(t *testing.T) {
+		requestsBefore := requests
+		_, err := priceOracle.Price(context.Background(), "ETH", "CHF", date)
+		assert.Nil(t, err)
+		assert.Equal(t, requestsBefore, requests)
+	})
+
+	t.Run("ShouldEvictLeastRecentlyUsedPrices", func(t *testing.T) {
+		priceOracle.cacheSize = 2
+		defer func() { priceOracle.cacheSize = httpPriceCacheSize }()
+
+		// ETH was used more recently than XES, the price of another date evicts XES
+		price, err := priceOracle.Price(context.Background(), "ETH", "CHF", date.Add(-time.Hour*24))
+		assert.Nil(t, err)
+		assert.Equal(t, "1890", price.FormatRate())
+		assert.Len(t, priceOracle.prices, 2)
+
+		requestsBefore := requests
+		_, err = priceOracle.Price(context.Background(), "ETH", "CHF", date)
+		assert.Nil(t, err)
+		assert.Equal(t, requestsBefore, requests)
+		_, err = priceOracle.Price(context.Background(), "XES", "CHF", date)
+		assert.Nil(t, err)
+		assert.Equal(t, requestsBefore+1, requests)
+		assert.Len(t, priceOracle.prices, 2)
+	})
+
+	t.Run("ShouldReturnUnknownPrices", func(t *testing.T) {
+		_, err := priceOracle.Price(context.Background(), "MKR", "CHF", date)
+		assert.Equal(t, errUnknownTokenPrice, err)
+		_, err = priceOracle.Price(context.Background(), "ZRX", "CHF", date)
+		assert.Equal(t, errUnknownTokenPrice, err)
+	})
+
+	t.Run("ShouldReturnErrors", func(t *testing.T) {
+		_, err := priceOracle.Price(context.Background(), "BAT", "CHF", date)
+		assert.NotNil(t, err)
+		assert.NotEqual(t, errUnknownTokenPrice, err)
+	})
+
+	_, err = NewHTTPPriceOracle(HTTPPriceOracleConfig{URL: server.URL})
+	assert.NotNil(t, err)
+}
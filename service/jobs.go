@@ -0,0 +1,473 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+type (
+	JobStatus string
+
+	// Asynchronous balance request
+	Job struct {
+		ID     string    `json:"id"`
+		Status JobStatus `json:"status"`
+		// Parameters of the job, kept to run it again if the process restarts before it's done
+		Request    json.RawMessage `json:"request"`
+		WebhookURL string          `json:"webhookUrl,omitempty"`
+		Progress   JobProgress     `json:"progress"`
+		Result     json.RawMessage `json:"result,omitempty"`
+		Error      string          `json:"error,omitempty"`
+		// Error of the last delivery attempt to WebhookURL, empty once delivered
+		WebhookError     string     `json:"webhookError,omitempty"`
+		WebhookDelivered bool       `json:"webhookDelivered,omitempty"`
+		CreatedAt        time.Time  `json:"createdAt"`
+		FinishedAt       *time.Time `json:"finishedAt,omitempty"`
+	}
+
+	// Sum of the progress of the "Transfer" logs scans of the job, both are 0 if the balance provider doesn't scan logs
+	JobProgress struct {
+		ScannedBlocks uint64 `json:"scannedBlocks"`
+		TotalBlocks   uint64 `json:"totalBlocks"`
+		ProcessedLogs uint64 `json:"processedLogs"`
+		// Estimated time left of the slowest scan, the scans of a job run concurrently
+		ETASeconds int64 `json:"etaSeconds"`
+	}
+
+	// Runs the request of a job, the result is marshalled to JSON
+	JobFunc func(ctx context.Context, request json.RawMessage) (interface{}, error)
+
+	JobRunner interface {
+		// Starts a job running request in the background. Once it's done, the job is posted to webhookURL if set.
+		Submit(request json.RawMessage, webhookURL string) (Job, error)
+		Get(id string) (Job, error)
+	}
+
+	// Runs jobs in the background, at most maxRunningJobs at a time. Jobs are stored as one JSON file per job in dir,
+	// jobs that were not done when the process stopped are run again when it starts. Jobs finished for longer than
+	// jobRetention are deleted on start and whenever a job is submitted.
+	jobRunner struct {
+		dir           string
+		run           JobFunc
+		timeout       time.Duration
+		webhookSecret string
+		httpClient    *http.Client
+		lock          sync.Mutex
+		jobs          map[string]*Job
+		scans         map[string]map[uint64]ScanProgress // job ID -> scan ID -> progress of the scans of running jobs
+		running       chan bool
+		// Lowercase host names webhooks can be posted to, any public host if empty
+		webhookHosts map[string]bool
+	}
+)
+
+const (
+	JobPending JobStatus = "pending"
+	JobRunning JobStatus = "running"
+	JobDone    JobStatus = "done"
+	JobFailed  JobStatus = "failed"
+
+	maxRunningJobs = 4
+	// Finished jobs are deleted after this duration
+	jobRetention = time.Hour * 24 * 7
+	// Amount of times the webhook of a job is called before giving up
+	maxWebhookAttempts = 5
+	// Header containing the HMAC-SHA256 of the webhook body, signed with the webhook secret
+	WebhookSignatureHeader = "X-Signature-256"
+)
+
+var (
+	jobIDRegexp = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+	// Networks of the local network and of the provider, besides the loopback and link-local ones
+	privateNetworks = mustParseCIDRs("10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "100.64.0.0/10", "fc00::/7")
+
+	ErrJobNotFound = errors.New("job not found")
+)
+
+// Opens the jobs stored in dir, creating it if it doesn't exist yet, and runs again the jobs that were not done. Jobs
+// are cancelled after timeout. Webhooks are signed with webhookSecret, they're refused if it's empty. If webhookHosts
+// is set, webhooks can only be posted to these hosts. Otherwise they can be posted to any host, but never to a loopback,
+// private or link-local address, so clients can't use webhooks to reach the internal services of the network.
+func NewJobRunner(dir string, run JobFunc, timeout time.Duration, webhookSecret string, webhookHosts []string) (*jobRunner, error) {
+	err := os.MkdirAll(dir, 0750)
+	if err != nil {
+		return nil, err
+	}
+
+	allowedHosts := make(map[string]bool, len(webhookHosts))
+	for _, host := range webhookHosts {
+		allowedHosts[strings.ToLower(host)] = true
+	}
+
+	runner := &jobRunner{
+		dir:           dir,
+		run:           run,
+		timeout:       timeout,
+		webhookSecret: webhookSecret,
+		webhookHosts:  allowedHosts,
+		httpClient: &http.Client{
+			Timeout:   time.Second * 30,
+			Transport: webhookTransport(len(allowedHosts) == 0),
+			// A redirect could lead to a host that isn't allowed, it's reported as a failed delivery instead
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		jobs:    make(map[string]*Job),
+		scans:   make(map[string]map[uint64]ScanProgress),
+		running: make(chan bool, maxRunningJobs),
+	}
+
+	err = runner.load()
+	if err != nil {
+		return nil, err
+	}
+
+	return runner, nil
+}
+
+func (me *jobRunner) Submit(request json.RawMessage, webhookURL string) (Job, error) {
+	if len(webhookURL) != 0 {
+		if len(me.webhookSecret) == 0 {
+			return Job{}, errors.New("webhooks are disabled, no webhook secret is configured")
+		}
+		parsedURL, err := url.Parse(webhookURL)
+		if err != nil || (parsedURL.Scheme != "http" && parsedURL.Scheme != "https") || len(parsedURL.Hostname()) == 0 {
+			return Job{}, fmt.Errorf("invalid webhook URL %q", webhookURL)
+		}
+		if len(me.webhookHosts) != 0 && !me.webhookHosts[strings.ToLower(parsedURL.Hostname())] {
+			return Job{}, fmt.Errorf("webhook host %s is not allowed", parsedURL.Hostname())
+		}
+		// Host names are checked once resolved, when the webhook is posted
+		if ip := net.ParseIP(parsedURL.Hostname()); len(me.webhookHosts) == 0 && ip != nil && !isPublicIP(ip) {
+			return Job{}, fmt.Errorf("webhook host %s is not allowed", parsedURL.Hostname())
+		}
+	}
+
+	id, err := newJobID()
+	if err != nil {
+		return Job{}, err
+	}
+	job := &Job{
+		ID:         id,
+		Status:     JobPending,
+		Request:    request,
+		WebhookURL: webhookURL,
+		CreatedAt:  time.Now().UTC(),
+	}
+
+	me.lock.Lock()
+	defer me.lock.Unlock()
+	me.pruneJobs()
+	err = me.save(job)
+	if err != nil {
+		return Job{}, err
+	}
+	me.jobs[id] = job
+
+	go me.runJob(job)
+	return *job, nil
+}
+
+func (me *jobRunner) Get(id string) (Job, error) {
+	me.lock.Lock()
+	defer me.lock.Unlock()
+
+	job, found := me.jobs[id]
+	if !found {
+		return Job{}, ErrJobNotFound
+	}
+	return *job, nil
+}
+
+func (me *jobRunner) runJob(job *Job) {
+	me.running <- true
+	defer func() { <-me.running }()
+
+	me.lock.Lock()
+	job.Status = JobRunning
+	me.scans[job.ID] = make(map[uint64]ScanProgress)
+	err := me.save(job)
+	me.lock.Unlock()
+	if err != nil {
+		log.Printf("[taxreporter][jobs] saving job %s err: %v", job.ID, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), me.timeout)
+	defer cancel()
+	ctx = WithScanProgress(ctx, func(progress ScanProgress) {
+		me.updateProgress(job, progress)
+	})
+
+	result, err := me.run(ctx, job.Request)
+	var resultJSON []byte
+	if err == nil {
+		resultJSON, err = json.Marshal(result)
+	}
+
+	me.lock.Lock()
+	finishedAt := time.Now().UTC()
+	job.FinishedAt = &finishedAt
+	if err != nil {
+		job.Status = JobFailed
+		job.Error = err.Error()
+	} else {
+		job.Status = JobDone
+		job.Result = resultJSON
+	}
+	delete(me.scans, job.ID)
+	err = me.save(job)
+	me.lock.Unlock()
+	if err != nil {
+		log.Printf("[taxreporter][jobs] saving job %s err: %v", job.ID, err)
+	}
+
+	// Retrying the webhook must not hold a running job slot
+	go me.deliverWebhook(job)
+}
+
+func (me *jobRunner) updateProgress(job *Job, progress ScanProgress) {
+	me.lock.Lock()
+	defer me.lock.Unlock()
+
+	scans, found := me.scans[job.ID]
+	if !found {
+		return
+	}
+	scans[progress.ScanID] = progress
+
+	job.Progress = JobProgress{}
+	for _, scan := range scans {
+		job.Progress.ScannedBlocks += scan.ScannedBlocks
+		job.Progress.TotalBlocks += scan.TotalBlocks
+		job.Progress.ProcessedLogs += scan.ProcessedLogs
+		if eta := int64(scan.ETA.Seconds()); eta > job.Progress.ETASeconds {
+			job.Progress.ETASeconds = eta
+		}
+	}
+}
+
+// Deletes the jobs finished for longer than jobRetention. Must be called with lock held.
+func (me *jobRunner) pruneJobs() {
+	for id, job := range me.jobs {
+		if !job.expired() {
+			continue
+		}
+		err := os.Remove(filepath.Join(me.dir, id+".json"))
+		if err != nil && !os.IsNotExist(err) {
+			log.Printf("[taxreporter][jobs] deleting job %s err: %v", id, err)
+			continue
+		}
+		delete(me.jobs, id)
+	}
+}
+
+// Posts the job to its webhook, retrying with an increasing delay if the webhook doesn't answer with a 2xx status
+func (me *jobRunner) deliverWebhook(job *Job) {
+	me.lock.Lock()
+	// Without secret, webhooks can't be signed
+	if len(job.WebhookURL) == 0 || job.WebhookDelivered || len(me.webhookSecret) == 0 {
+		me.lock.Unlock()
+		return
+	}
+	body, err := json.Marshal(job)
+	me.lock.Unlock()
+	if err != nil {
+		log.Printf("[taxreporter][jobs] marshalling job %s err: %v", job.ID, err)
+		return
+	}
+
+	delay := time.Second
+	for attempt := 1; attempt <= maxWebhookAttempts; attempt++ {
+		err = me.postWebhook(job.WebhookURL, body)
+		if err == nil {
+			break
+		}
+		log.Printf("[taxreporter][jobs] webhook of job %s, attempt %d err: %v", job.ID, attempt, err)
+		if attempt < maxWebhookAttempts {
+			time.Sleep(delay)
+			delay *= 2
+		}
+	}
+
+	me.lock.Lock()
+	defer me.lock.Unlock()
+	if err != nil {
+		job.WebhookError = err.Error()
+	} else {
+		job.WebhookError = ""
+		job.WebhookDelivered = true
+	}
+	err = me.save(job)
+	if err != nil {
+		log.Printf("[taxreporter][jobs] saving job %s err: %v", job.ID, err)
+	}
+}
+
+func (me *jobRunner) postWebhook(url string, body []byte) error {
+	request, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(WebhookSignatureHeader, "sha256="+SignWebhook(me.webhookSecret, body))
+
+	response, err := me.httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return fmt.Errorf("webhook answered with status %d", response.StatusCode)
+	}
+	return nil
+}
+
+// Without allowed hosts, the addresses webhook hosts resolve to are checked when connecting: checking them on submit
+// wouldn't stop a host name resolving to another address later. Proxies are not used, they'd connect instead.
+func webhookTransport(publicOnly bool) http.RoundTripper {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if publicOnly {
+		dialer := &net.Dialer{
+			Timeout:   time.Second * 30,
+			KeepAlive: time.Second * 30,
+			Control:   checkPublicAddress,
+		}
+		transport.DialContext = dialer.DialContext
+		transport.Proxy = nil
+	}
+	return transport
+}
+
+func checkPublicAddress(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !isPublicIP(ip) {
+		return fmt.Errorf("webhook address %s is not allowed", host)
+	}
+	return nil
+}
+
+func isPublicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified() {
+		return false
+	}
+	for _, network := range privateNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks[i] = network
+	}
+	return networks
+}
+
+// Hex encoded HMAC-SHA256 of body, the receiver of a webhook computes it to make sure the webhook comes from this service
+func SignWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Loads the stored jobs, deletes the ones finished for longer than jobRetention and resumes the others
+func (me *jobRunner) load() error {
+	paths, err := filepath.Glob(filepath.Join(me.dir, "*.json"))
+	if err != nil {
+		return err
+	}
+
+	for _, path := range paths {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		job := &Job{}
+		err = json.Unmarshal(content, job)
+		if err != nil {
+			return fmt.Errorf("reading job %s. error: %v", path, err)
+		}
+
+		if job.expired() {
+			err = os.Remove(path)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		me.jobs[job.ID] = job
+		switch job.Status {
+		case JobPending, JobRunning:
+			job.Status = JobPending
+			job.Progress = JobProgress{}
+			go me.runJob(job)
+		default:
+			go me.deliverWebhook(job)
+		}
+	}
+
+	return nil
+}
+
+// Writes to a temporary file first, so a crash never leaves a half written job behind. Must be called with lock held.
+func (me *jobRunner) save(job *Job) error {
+	if !jobIDRegexp.MatchString(job.ID) {
+		return fmt.Errorf("invalid job id %q", job.ID)
+	}
+	content, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(me.dir, job.ID+".json")
+	err = ioutil.WriteFile(path+".tmp", content, 0640)
+	if err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+func (me *Job) expired() bool {
+	return me.FinishedAt != nil && time.Since(*me.FinishedAt) > jobRetention
+}
+
+func newJobID() (string, error) {
+	id := make([]byte, 16)
+	_, err := rand.Read(id)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
@@ -0,0 +1,192 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJobRunner(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jobs")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	run := func(ctx context.Context, request json.RawMessage) (interface{}, error) {
+		var address string
+		err := json.Unmarshal(request, &address)
+		if err != nil {
+			return nil, err
+		}
+		if address == "fail" {
+			return nil, errors.New("eth error")
+		}
+
+		reportProgress := newScanProgressFunc(ctx)
+		reportProgress(ScanProgress{ScannedBlocks: 400, TotalBlocks: 1000, ProcessedLogs: 3, ETA: time.Minute})
+		reportProgress(ScanProgress{ScannedBlocks: 1000, TotalBlocks: 1000, ProcessedLogs: 7})
+		return map[string]string{"address": address, "ETH": "1"}, nil
+	}
+
+	webhookSecret := "webhook secret"
+	webhookCalls := make(chan *http.Request, 10)
+	webhookBodies := make(chan []byte, 10)
+	webhookServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		webhookCalls <- r
+		webhookBodies <- body
+	}))
+	defer webhookServer.Close()
+
+	// The webhook server listens on a loopback address, which is only allowed if listed
+	runner, err := NewJobRunner(dir, run, time.Minute, webhookSecret, []string{"hooks.example.com", "127.0.0.1"})
+	assert.Nil(t, err)
+
+	t.Run("ShouldRunJob", func(t *testing.T) {
+		job, err := runner.Submit(json.RawMessage(`"0x1"`), "")
+		assert.Nil(t, err)
+		assert.Len(t, job.ID, 32)
+
+		job = waitForJob(t, runner, job.ID)
+		assert.Equal(t, JobDone, job.Status)
+		assert.JSONEq(t, `{"address": "0x1", "ETH": "1"}`, string(job.Result))
+		assert.Equal(t, JobProgress{ScannedBlocks: 1000, TotalBlocks: 1000, ProcessedLogs: 7}, job.Progress)
+	})
+
+	t.Run("ShouldReportError", func(t *testing.T) {
+		job, err := runner.Submit(json.RawMessage(`"fail"`), "")
+		assert.Nil(t, err)
+
+		job = waitForJob(t, runner, job.ID)
+		assert.Equal(t, JobFailed, job.Status)
+		assert.Equal(t, "eth error", job.Error)
+	})
+
+	t.Run("ShouldCallSignedWebhook", func(t *testing.T) {
+		job, err := runner.Submit(json.RawMessage(`"0x1"`), webhookServer.URL)
+		assert.Nil(t, err)
+
+		select {
+		case request := <-webhookCalls:
+			body := <-webhookBodies
+			assert.Equal(t, "sha256="+SignWebhook(webhookSecret, body), request.Header.Get(WebhookSignatureHeader))
+
+			webhookJob := Job{}
+			assert.Nil(t, json.Unmarshal(body, &webhookJob))
+			assert.Equal(t, job.ID, webhookJob.ID)
+			assert.Equal(t, JobDone, webhookJob.Status)
+		case <-time.After(time.Second * 5):
+			t.Fatal("webhook not called")
+		}
+	})
+
+	t.Run("ShouldRejectInvalidWebhook", func(t *testing.T) {
+		_, err := runner.Submit(json.RawMessage(`"0x1"`), "ftp://example.com")
+		assert.NotNil(t, err)
+
+		otherDir, err := ioutil.TempDir("", "jobs")
+		assert.Nil(t, err)
+		defer os.RemoveAll(otherDir)
+		runnerWithoutSecret, err := NewJobRunner(otherDir, run, time.Minute, "", nil)
+		assert.Nil(t, err)
+		_, err = runnerWithoutSecret.Submit(json.RawMessage(`"0x1"`), webhookServer.URL)
+		assert.NotNil(t, err)
+	})
+
+	t.Run("ShouldOnlyAllowWebhookHosts", func(t *testing.T) {
+		_, err := runner.Submit(json.RawMessage(`"0x1"`), "https://internal.example.com/jobs")
+		assert.NotNil(t, err)
+	})
+
+	t.Run("ShouldRefuseInternalWebhooksWithoutHosts", func(t *testing.T) {
+		otherDir, err := ioutil.TempDir("", "jobs")
+		assert.Nil(t, err)
+		defer os.RemoveAll(otherDir)
+		unrestrictedRunner, err := NewJobRunner(otherDir, run, time.Minute, webhookSecret, nil)
+		assert.Nil(t, err)
+
+		for _, webhookURL := range []string{webhookServer.URL, "http://10.0.0.1/jobs", "http://169.254.169.254/latest", "http://[::1]:8080/jobs"} {
+			_, err = unrestrictedRunner.Submit(json.RawMessage(`"0x1"`), webhookURL)
+			assert.NotNil(t, err, webhookURL)
+		}
+
+		// Host names resolving to an internal address are refused when connecting
+		localhostURL := strings.Replace(webhookServer.URL, "127.0.0.1", "localhost", 1)
+		err = unrestrictedRunner.postWebhook(localhostURL, []byte(`{}`))
+		assert.NotNil(t, err)
+		assert.Empty(t, webhookCalls)
+	})
+
+	t.Run("ShouldDeleteExpiredJobsOnSubmit", func(t *testing.T) {
+		finishedAt := time.Now().Add(-jobRetention - time.Hour)
+		expiredJob := &Job{ID: "fedcba9876543210fedcba9876543210", Status: JobDone, FinishedAt: &finishedAt}
+		runner.lock.Lock()
+		assert.Nil(t, runner.save(expiredJob))
+		runner.jobs[expiredJob.ID] = expiredJob
+		runner.lock.Unlock()
+
+		_, err := runner.Submit(json.RawMessage(`"0x1"`), "")
+		assert.Nil(t, err)
+
+		_, err = runner.Get(expiredJob.ID)
+		assert.Equal(t, ErrJobNotFound, err)
+		_, err = os.Stat(filepath.Join(dir, expiredJob.ID+".json"))
+		assert.True(t, os.IsNotExist(err))
+	})
+
+	t.Run("ShouldReturnUnknownJob", func(t *testing.T) {
+		_, err := runner.Get("00000000000000000000000000000000")
+		assert.Equal(t, ErrJobNotFound, err)
+	})
+}
+
+func TestJobRunner_resume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jobs")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	// A job that was running when the process stopped, and one that finished long ago
+	runningJob := Job{ID: "0123456789abcdef0123456789abcdef", Status: JobRunning, Request: json.RawMessage(`"0x1"`)}
+	finishedAt := time.Now().Add(-jobRetention - time.Hour)
+	oldJob := Job{ID: "fedcba9876543210fedcba9876543210", Status: JobDone, FinishedAt: &finishedAt}
+	for _, job := range []Job{runningJob, oldJob} {
+		content, err := json.Marshal(job)
+		assert.Nil(t, err)
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, job.ID+".json"), content, 0640))
+	}
+
+	runner, err := NewJobRunner(dir, func(ctx context.Context, request json.RawMessage) (interface{}, error) {
+		return "resumed", nil
+	}, time.Minute, "", nil)
+	assert.Nil(t, err)
+
+	job := waitForJob(t, runner, runningJob.ID)
+	assert.Equal(t, JobDone, job.Status)
+	assert.Equal(t, `"resumed"`, string(job.Result))
+
+	_, err = runner.Get(oldJob.ID)
+	assert.Equal(t, ErrJobNotFound, err)
+	_, err = os.Stat(filepath.Join(dir, oldJob.ID+".json"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func waitForJob(t *testing.T, runner JobRunner, id string) Job {
+	deadline := time.Now().Add(time.Second * 5)
+	for {
+		job, err := runner.Get(id)
+		assert.Nil(t, err)
+		if job.Status == JobDone || job.Status == JobFailed || time.Now().After(deadline) {
+			return job
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
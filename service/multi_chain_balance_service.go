@@ -0,0 +1,128 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+)
+
+type (
+	MultiChainBalanceService interface {
+		// Returns the balances on every chain, in the order the chains were configured
+		GetBalances(ctx context.Context, ethAddress string) ([]ChainBalances, error)
+		// Same as GetBalances, but the balances of each chain are calculated at its last block mined at or before date
+		GetBalancesAtDate(ctx context.Context, ethAddress string, date time.Time) ([]ChainBalances, error)
+		// Same as GetBalances, but the balances are calculated at blockNumber on every chain. Block numbers being
+		// specific to a chain, it's mostly useful with a single chain.
+		GetBalancesAtBlock(ctx context.Context, ethAddress string, blockNumber *big.Int) ([]ChainBalances, error)
+		// Restricts the balances to the chains of networks, all the chains if networks is empty
+		Select(networks []string) (MultiChainBalanceService, error)
+	}
+
+	// Balances service of one chain
+	ChainBalanceService struct {
+		Network        string
+		BalanceService EthereumBalanceService
+	}
+
+	ChainBalances struct {
+		Network  string
+		Balances map[string]*TokenAmount
+		// Nil if the balance service of the chain doesn't know at which block the balances were calculated
+		Block *BalancesBlock
+		// Problems that didn't prevent retrieving the balances, e.g. ignored tokens impersonating a configured token
+		Warnings []string
+	}
+
+	multiChainBalanceService struct {
+		chainBalanceServices []ChainBalanceService
+	}
+)
+
+func NewMultiChainBalanceService(chainBalanceServices []ChainBalanceService) *multiChainBalanceService {
+	return &multiChainBalanceService{chainBalanceServices: chainBalanceServices}
+}
+
+func (me *multiChainBalanceService) GetBalances(ctx context.Context, ethAddress string) ([]ChainBalances, error) {
+	return me.fanOut(ctx, func(ctx context.Context, balanceService EthereumBalanceService) (*TokenBalances, error) {
+		return balanceService.GetBalances(ctx, ethAddress)
+	})
+}
+
+func (me *multiChainBalanceService) GetBalancesAtDate(ctx context.Context, ethAddress string, date time.Time) ([]ChainBalances, error) {
+	return me.fanOut(ctx, func(ctx context.Context, balanceService EthereumBalanceService) (*TokenBalances, error) {
+		return balanceService.GetBalancesAtDate(ctx, ethAddress, date)
+	})
+}
+
+func (me *multiChainBalanceService) GetBalancesAtBlock(ctx context.Context, ethAddress string, blockNumber *big.Int) ([]ChainBalances, error) {
+	return me.fanOut(ctx, func(ctx context.Context, balanceService EthereumBalanceService) (*TokenBalances, error) {
+		return balanceService.GetBalancesAtBlock(ctx, ethAddress, blockNumber)
+	})
+}
+
+func (me *multiChainBalanceService) Select(networks []string) (MultiChainBalanceService, error) {
+	if len(networks) == 0 {
+		return me, nil
+	}
+
+	var selected []ChainBalanceService
+	for _, network := range networks {
+		found := false
+		for _, chainBalanceService := range me.chainBalanceServices {
+			if chainBalanceService.Network == network {
+				selected = append(selected, chainBalanceService)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("network %s isn't configured", network)
+		}
+	}
+	return NewMultiChainBalanceService(selected), nil
+}
+
+// Calls getBalances on every chain concurrently. The first error cancels the calls still running on the other chains.
+func (me *multiChainBalanceService) fanOut(ctx context.Context, getBalances func(ctx context.Context, balanceService EthereumBalanceService) (*TokenBalances, error)) ([]ChainBalances, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		results      = make([]ChainBalances, len(me.chainBalanceServices))
+		wg           sync.WaitGroup
+		firstErr     error
+		firstErrLock sync.Mutex
+	)
+	for i, chainBalanceService := range me.chainBalanceServices {
+		wg.Add(1)
+		go func(i int, chainBalanceService ChainBalanceService) {
+			defer wg.Done()
+
+			balances, err := getBalances(ctx, chainBalanceService.BalanceService)
+			if err != nil {
+				firstErrLock.Lock()
+				if firstErr == nil {
+					firstErr = fmt.Errorf("network %s: %w", chainBalanceService.Network, err)
+					cancel()
+				}
+				firstErrLock.Unlock()
+				return
+			}
+			results[i] = ChainBalances{
+				Network:  chainBalanceService.Network,
+				Balances: balances.Balances,
+				Block:    balances.Block,
+				Warnings: balances.Warnings,
+			}
+		}(i, chainBalanceService)
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return results, nil
+}
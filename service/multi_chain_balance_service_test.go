@@ -0,0 +1,112 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMultiChainBalanceService_GetBalances(t *testing.T) {
+	returnMap := sync.Map{}
+	returnMap.Store(NativeAssetKey, big.NewInt(1000000000000000000))
+	returnMap.Store("USDC", big.NewInt(2000000))
+	ctx := context.WithValue(context.Background(), "returnMap", returnMap)
+
+	t.Run("ShouldReturnBalancesOfEveryChain", func(t *testing.T) {
+		multiChainBalanceService := NewMultiChainBalanceService([]ChainBalanceService{
+			{Network: "mainnet", BalanceService: NewEthereumBalanceService(&ethBalanceStub{}, nil, tokenDecimalsStub{"USDC": 6}, "ETH")},
+			{Network: "polygon", BalanceService: NewEthereumBalanceService(&ethBalanceStub{}, nil, tokenDecimalsStub{"USDC": 6}, "MATIC")},
+			{Network: "bsc", BalanceService: NewEthereumBalanceService(&ethBalanceStub{}, nil, tokenDecimalsStub{"USDC": 18}, "BNB")},
+		})
+
+		chainsBalances, err := multiChainBalanceService.GetBalances(ctx, "0x1")
+		assert.Nil(t, err)
+		assert.Len(t, chainsBalances, 3)
+
+		assert.Equal(t, "mainnet", chainsBalances[0].Network)
+		assert.Equal(t, "1", chainsBalances[0].Balances["ETH"].String())
+		assert.Equal(t, "2", chainsBalances[0].Balances["USDC"].String())
+		assert.Equal(t, "polygon", chainsBalances[1].Network)
+		assert.Equal(t, "1", chainsBalances[1].Balances["MATIC"].String())
+		assert.Equal(t, "bsc", chainsBalances[2].Network)
+		assert.Equal(t, "1", chainsBalances[2].Balances["BNB"].String())
+		assert.Equal(t, "0.000000000002", chainsBalances[2].Balances["USDC"].String())
+	})
+
+	t.Run("ShouldReturnWarningsOfEveryChain", func(t *testing.T) {
+		multiChainBalanceService := NewMultiChainBalanceService([]ChainBalanceService{
+			{Network: "mainnet", BalanceService: NewEthereumBalanceService(&ethBalanceStub{}, nil, tokenDecimalsStub{"USDC": 6}, "ETH")},
+			{Network: "polygon", BalanceService: NewEthereumBalanceService(&ethBalanceStub{}, nil, tokenDecimalsStub{"USDC": 6}, "MATIC")},
+		})
+
+		chainsBalances, err := multiChainBalanceService.GetBalances(context.WithValue(ctx, "returnWarning", "ignored token"), "0x1")
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"ignored token"}, chainsBalances[0].Warnings)
+		assert.Equal(t, []string{"ignored token"}, chainsBalances[1].Warnings)
+
+		chainsBalances, err = multiChainBalanceService.GetBalances(ctx, "0x1")
+		assert.Nil(t, err)
+		assert.Nil(t, chainsBalances[0].Warnings)
+	})
+
+	t.Run("ShouldReturnErrorOfAnyChain", func(t *testing.T) {
+		multiChainBalanceService := NewMultiChainBalanceService([]ChainBalanceService{
+			{Network: "mainnet", BalanceService: NewEthereumBalanceService(&ethBalanceStub{}, nil, nil, "ETH")},
+			{Network: "polygon", BalanceService: NewEthereumBalanceService(&ethBalanceStub{}, nil, tokenDecimalsStub{}, "MATIC")},
+		})
+
+		_, err := multiChainBalanceService.GetBalances(ctx, "0x1")
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), "polygon")
+	})
+
+	t.Run("ShouldReturnErrorOfBalanceService", func(t *testing.T) {
+		expectedError := errors.New("eth error")
+		multiChainBalanceService := NewMultiChainBalanceService([]ChainBalanceService{
+			{Network: "mainnet", BalanceService: NewEthereumBalanceService(&ethBalanceStub{}, nil, nil, "ETH")},
+		})
+
+		_, err := multiChainBalanceService.GetBalances(context.WithValue(ctx, "returnErr", expectedError), "0x1")
+		assert.NotNil(t, err)
+	})
+}
+
+func TestMultiChainBalanceService_Select(t *testing.T) {
+	returnMap := sync.Map{}
+	returnMap.Store(NativeAssetKey, big.NewInt(1000000000000000000))
+	ctx := context.WithValue(context.Background(), "returnMap", returnMap)
+
+	multiChainBalanceService := NewMultiChainBalanceService([]ChainBalanceService{
+		{Network: "mainnet", BalanceService: NewEthereumBalanceService(&ethBalanceStub{}, nil, nil, "ETH")},
+		{Network: "polygon", BalanceService: NewEthereumBalanceService(&ethBalanceStub{}, nil, nil, "MATIC")},
+	})
+
+	t.Run("ShouldOnlyReturnSelectedChains", func(t *testing.T) {
+		selected, err := multiChainBalanceService.Select([]string{"polygon"})
+		assert.Nil(t, err)
+
+		chainsBalances, err := selected.GetBalances(ctx, "0x1")
+		assert.Nil(t, err)
+		assert.Len(t, chainsBalances, 1)
+		assert.Equal(t, "polygon", chainsBalances[0].Network)
+		assert.Equal(t, "1", chainsBalances[0].Balances["MATIC"].String())
+	})
+
+	t.Run("ShouldSelectAllChainsByDefault", func(t *testing.T) {
+		selected, err := multiChainBalanceService.Select(nil)
+		assert.Nil(t, err)
+
+		chainsBalances, err := selected.GetBalances(ctx, "0x1")
+		assert.Nil(t, err)
+		assert.Len(t, chainsBalances, 2)
+	})
+
+	t.Run("ShouldRejectUnknownNetwork", func(t *testing.T) {
+		_, err := multiChainBalanceService.Select([]string{"bsc"})
+		assert.NotNil(t, err)
+	})
+}
@@ -0,0 +1,36 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+
+	"github.com/ProxeusApp/node-balance-retriever/blockchain"
+)
+
+// Subset of the rpcEthereumClient methods identifying the network a client is connected to
+type NetworkClient interface {
+	ChainID(ctx context.Context) (*big.Int, error)
+	BlockHeaderByNumber(ctx context.Context, number *big.Int) (*BlockHeader, error)
+}
+
+// Makes sure client is connected to network: same chain ID and same genesis block
+func VerifyNetwork(ctx context.Context, client NetworkClient, network blockchain.Network) error {
+	chainID, err := client.ChainID(ctx)
+	if err != nil {
+		return fmt.Errorf("retrieving chain ID. error: %v", err)
+	}
+	if !chainID.IsUint64() || chainID.Uint64() != network.ChainID {
+		return fmt.Errorf("connected to chain ID %s, expected %d (%s)", chainID, network.ChainID, network.Name)
+	}
+
+	genesisHeader, err := client.BlockHeaderByNumber(ctx, big.NewInt(0))
+	if err != nil {
+		return fmt.Errorf("retrieving genesis block. error: %v", err)
+	}
+	if genesisHeader.Hash != network.GenesisHash {
+		return fmt.Errorf("connected to a chain with genesis block %s, expected %s (%s)", genesisHeader.Hash.Hex(), network.GenesisHash.Hex(), network.Name)
+	}
+
+	return nil
+}
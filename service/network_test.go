@@ -0,0 +1,104 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ProxeusApp/node-balance-retriever/blockchain"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifyNetwork(t *testing.T) {
+	ctx := context.Background()
+	ethClient := NewEthClientStub()
+	genesisHeader, err := ethClient.BlockHeaderByNumber(ctx, big.NewInt(0))
+	assert.Nil(t, err)
+
+	t.Run("same network", func(t *testing.T) {
+		err := VerifyNetwork(ctx, ethClient, blockchain.Network{Name: "stub", ChainID: 1, GenesisHash: genesisHeader.Hash})
+		assert.Nil(t, err)
+	})
+
+	t.Run("other chain ID", func(t *testing.T) {
+		err := VerifyNetwork(ctx, ethClient, blockchain.Networks["sepolia"])
+		assert.NotNil(t, err)
+	})
+
+	t.Run("other genesis block", func(t *testing.T) {
+		err := VerifyNetwork(ctx, ethClient, blockchain.Network{Name: "fork", ChainID: 1, GenesisHash: common.HexToHash("0x01")})
+		assert.NotNil(t, err)
+	})
+}
+
+// Genesis block of Sepolia as returned by "eth_getBlockByNumber", it has a base fee
+const sepoliaGenesisBlock = `{
+	"baseFeePerGas": "0x3b9aca00",
+	"difficulty": "0x20000",
+	"extraData": "0x5365706f6c69612c20417468656e732c204174746963612c2047726565636521",
+	"gasLimit": "0x1c9c380",
+	"gasUsed": "0x0",
+	"hash": "0x25a5cc106eea7138acab33231d7160d69cb777ee0c2c553fcddf5138993e6dd9",
+	"logsBloom": "0x00000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000",
+	"miner": "0x0000000000000000000000000000000000000000",
+	"mixHash": "0x0000000000000000000000000000000000000000000000000000000000000000",
+	"nonce": "0x0000000000000000",
+	"number": "0x0",
+	"parentHash": "0x0000000000000000000000000000000000000000000000000000000000000000",
+	"receiptsRoot": "0x56e81f171bcc55a6ff8345e692c0f86e5b48e01b996cadc001622fb5e363b421",
+	"sha3Uncles": "0x1dcc4de8dec75d7aab85b567b6ccd41ad312451b948a7413f0a142fd40d49347",
+	"size": "0x225",
+	"stateRoot": "0x5eb6e371a698b8d68f665192350ffcecbbbf322916f4b51bd79bb6887da3f494",
+	"timestamp": "0x6159af19",
+	"totalDifficulty": "0x20000",
+	"transactions": [],
+	"transactionsRoot": "0x56e81f171bcc55a6ff8345e692c0f86e5b48e01b996cadc001622fb5e363b421",
+	"uncles": []
+}`
+
+func TestVerifyNetwork_sepolia(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			ID     json.RawMessage   `json:"id"`
+			Method string            `json:"method"`
+			Params []json.RawMessage `json:"params"`
+		}
+		err := json.NewDecoder(r.Body).Decode(&request)
+		assert.Nil(t, err)
+
+		result := "null"
+		switch {
+		case request.Method == "eth_chainId":
+			result = `"0xaa36a7"`
+		case request.Method == "eth_getBlockByNumber" && string(request.Params[0]) == `"0x0"`:
+			result = sepoliaGenesisBlock
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"jsonrpc":"2.0","id":` + string(request.ID) + `,"result":` + result + `}`))
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	ethClient, err := DialEthereumClient(ctx, server.URL)
+	assert.Nil(t, err)
+
+	genesisHeader, err := ethClient.BlockHeaderByNumber(ctx, big.NewInt(0))
+	assert.Nil(t, err)
+	assert.Equal(t, blockchain.Networks["sepolia"].GenesisHash, genesisHeader.Hash)
+	assert.Equal(t, uint64(0x6159af19), genesisHeader.Time)
+	// The header doesn't know the base fee, the hash it computes is wrong
+	assert.NotEqual(t, genesisHeader.Hash, genesisHeader.Header.Hash())
+
+	err = VerifyNetwork(ctx, ethClient, blockchain.Networks["sepolia"])
+	assert.Nil(t, err)
+	err = VerifyNetwork(ctx, ethClient, blockchain.Network{Name: "other", ChainID: 11155111, GenesisHash: common.HexToHash("0x01")})
+	assert.NotNil(t, err)
+
+	_, err = ethClient.BlockHeaderByNumber(ctx, big.NewInt(1))
+	assert.Equal(t, ethereum.NotFound, err)
+}
@@ -0,0 +1,243 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// Settings of one node instance of a workflow, chosen by the workflow designer in the config form
+	NodeConfig struct {
+		// Networks to retrieve the balances on, all the configured networks if empty
+		Networks []string `json:"networks,omitempty"`
+		// Symbols of the tokens (or native assets) to return, all of them if empty
+		Tokens []string `json:"tokens,omitempty"`
+		// ISO-8601 date with timezone, used when the workflow data has no balanceDate
+		BalanceDate string `json:"balanceDate,omitempty"`
+		// Default name of an output field (e.g. "XES" or "polygon.USDC") -> name written to the workflow data
+		OutputFields map[string]string `json:"outputFields,omitempty"`
+		// Paths of the input fields in the workflow data (see LookupPath), "ethAddress" and "balanceDate" if empty
+		AddressPath     string `json:"addressPath,omitempty"`
+		BalanceDatePath string `json:"balanceDatePath,omitempty"`
+		// Paths of several address fields, used instead of AddressPath if set
+		AddressPaths []string `json:"addressPaths,omitempty"`
+		// Path of the sub-object the output fields are written to (e.g. "balances" for "balances.XES"), the top level
+		// of the workflow data if empty
+		OutputObject string `json:"outputObject,omitempty"`
+		// Prepended to the name of every output field
+		OutputPrefix string `json:"outputPrefix,omitempty"`
+		// Fails instead of overwriting fields already present in the workflow data
+		FailOnOverwrite bool `json:"failOnOverwrite,omitempty"`
+		// Adds the value of the balances in fiat currency and their total, if the price oracle knows the prices
+		FiatValues bool `json:"fiatValues,omitempty"`
+	}
+
+	NodeConfigStore interface {
+		// Returns the config of the node instance, an empty config if it was never saved
+		Get(id string) (NodeConfig, error)
+		Save(id string, config NodeConfig) error
+		// Deletes the config of a node instance removed from its workflow
+		Delete(id string) error
+		// Releases the memory held for a node instance, its config stays stored
+		Close(id string)
+	}
+
+	// Configs of the node instances, stored as one JSON file per node id. Configs are loaded lazily and kept in memory
+	// until the instance is closed.
+	nodeConfigStore struct {
+		dir     string
+		lock    sync.Mutex
+		configs map[string]*NodeConfig
+	}
+)
+
+const (
+	defaultAddressPath     = "ethAddress"
+	defaultBalanceDatePath = "balanceDate"
+)
+
+var (
+	nodeIDRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+	errInvalidNodeID = errors.New("invalid node id")
+)
+
+func (me *NodeConfig) Validate() error {
+	if len(me.BalanceDate) != 0 {
+		_, err := time.Parse(time.RFC3339, me.BalanceDate)
+		if err != nil {
+			return fmt.Errorf("invalid balanceDate: %v", err)
+		}
+	}
+	for _, path := range append([]string{me.AddressPath, me.BalanceDatePath, me.OutputObject}, me.AddressPaths...) {
+		if len(path) != 0 && strings.Contains("."+path+".", "..") {
+			return fmt.Errorf("invalid path %q", path)
+		}
+	}
+	for field, name := range me.OutputFields {
+		if len(name) == 0 {
+			return fmt.Errorf("output field %s has an empty name", field)
+		}
+	}
+	return nil
+}
+
+// Selects the tokens the instance returns, all of them if no token is configured
+func (me *NodeConfig) IncludesToken(symbol string) bool {
+	if len(me.Tokens) == 0 {
+		return true
+	}
+	for _, token := range me.Tokens {
+		if token == symbol {
+			return true
+		}
+	}
+	return false
+}
+
+// Name of the output field, renamed if configured
+func (me *NodeConfig) OutputField(field string) string {
+	if name, ok := me.OutputFields[field]; ok {
+		return name
+	}
+	return field
+}
+
+// Each address field can hold an address or an array of addresses
+func (me *NodeConfig) InputAddressPaths() []string {
+	if len(me.AddressPaths) != 0 {
+		return me.AddressPaths
+	}
+	if len(me.AddressPath) == 0 {
+		return []string{defaultAddressPath}
+	}
+	return []string{me.AddressPath}
+}
+
+func (me *NodeConfig) InputBalanceDatePath() string {
+	if len(me.BalanceDatePath) == 0 {
+		return defaultBalanceDatePath
+	}
+	return me.BalanceDatePath
+}
+
+// Opens the store in dir, creating it if it doesn't exist yet
+func NewNodeConfigStore(dir string) (*nodeConfigStore, error) {
+	err := os.MkdirAll(dir, 0750)
+	if err != nil {
+		return nil, err
+	}
+
+	return &nodeConfigStore{
+		dir:     dir,
+		configs: make(map[string]*NodeConfig),
+	}, nil
+}
+
+func (me *nodeConfigStore) Get(id string) (NodeConfig, error) {
+	me.lock.Lock()
+	defer me.lock.Unlock()
+
+	config, err := me.load(id)
+	if err != nil {
+		return NodeConfig{}, err
+	}
+	return *config, nil
+}
+
+// Writes to a temporary file first, so a crash never leaves a half written config behind
+func (me *nodeConfigStore) Save(id string, config NodeConfig) error {
+	path, err := me.path(id)
+	if err != nil {
+		return err
+	}
+	err = config.Validate()
+	if err != nil {
+		return err
+	}
+	content, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+
+	me.lock.Lock()
+	defer me.lock.Unlock()
+
+	err = ioutil.WriteFile(path+".tmp", content, 0640)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(path+".tmp", path)
+	if err != nil {
+		return err
+	}
+
+	me.configs[id] = &config
+	return nil
+}
+
+func (me *nodeConfigStore) Delete(id string) error {
+	path, err := me.path(id)
+	if err != nil {
+		return err
+	}
+
+	me.lock.Lock()
+	defer me.lock.Unlock()
+
+	delete(me.configs, id)
+	err = os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (me *nodeConfigStore) Close(id string) {
+	me.lock.Lock()
+	defer me.lock.Unlock()
+
+	delete(me.configs, id)
+}
+
+func (me *nodeConfigStore) load(id string) (*NodeConfig, error) {
+	if config, ok := me.configs[id]; ok {
+		return config, nil
+	}
+
+	path, err := me.path(id)
+	if err != nil {
+		return nil, err
+	}
+	config := &NodeConfig{}
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return config, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(content, config)
+	if err != nil {
+		return nil, fmt.Errorf("reading config of node %s. error: %v", id, err)
+	}
+
+	me.configs[id] = config
+	return config, nil
+}
+
+// The node id comes from the request path, make sure it can't point outside of the store
+func (me *nodeConfigStore) path(id string) (string, error) {
+	if !nodeIDRegexp.MatchString(id) {
+		return "", errInvalidNodeID
+	}
+	return filepath.Join(me.dir, id+".json"), nil
+}
@@ -0,0 +1,105 @@
+package service
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNodeConfigStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "node-config")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	store, err := NewNodeConfigStore(dir)
+	assert.Nil(t, err)
+
+	t.Run("ShouldReturnEmptyConfigIfNeverSaved", func(t *testing.T) {
+		config, err := store.Get("node1")
+		assert.Nil(t, err)
+		assert.Equal(t, NodeConfig{}, config)
+	})
+
+	t.Run("ShouldPersistConfig", func(t *testing.T) {
+		config := NodeConfig{
+			Networks:     []string{"polygon"},
+			Tokens:       []string{"USDC"},
+			BalanceDate:  "2019-12-31T23:59:59+01:00",
+			OutputFields: map[string]string{"USDC": "usdcBalance"},
+		}
+		err := store.Save("node1", config)
+		assert.Nil(t, err)
+
+		// A new store reads the config back from disk
+		reopenedStore, err := NewNodeConfigStore(dir)
+		assert.Nil(t, err)
+		storedConfig, err := reopenedStore.Get("node1")
+		assert.Nil(t, err)
+		assert.Equal(t, config, storedConfig)
+	})
+
+	t.Run("ShouldKeepConfigOnClose", func(t *testing.T) {
+		store.Close("node1")
+
+		config, err := store.Get("node1")
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"USDC"}, config.Tokens)
+	})
+
+	t.Run("ShouldDeleteConfig", func(t *testing.T) {
+		err := store.Delete("node1")
+		assert.Nil(t, err)
+
+		_, err = os.Stat(filepath.Join(dir, "node1.json"))
+		assert.True(t, os.IsNotExist(err))
+		config, err := store.Get("node1")
+		assert.Nil(t, err)
+		assert.Equal(t, NodeConfig{}, config)
+
+		// Removing a node that was never configured isn't an error
+		assert.Nil(t, store.Delete("node2"))
+	})
+
+	t.Run("ShouldRejectInvalidNodeID", func(t *testing.T) {
+		err := store.Save("../node1", NodeConfig{})
+		assert.Equal(t, errInvalidNodeID, err)
+		_, err = store.Get("")
+		assert.Equal(t, errInvalidNodeID, err)
+	})
+
+	t.Run("ShouldRejectInvalidBalanceDate", func(t *testing.T) {
+		err := store.Save("node1", NodeConfig{BalanceDate: "2019-12-31"})
+		assert.NotNil(t, err)
+	})
+
+	t.Run("ShouldRejectInvalidPath", func(t *testing.T) {
+		err := store.Save("node1", NodeConfig{OutputObject: "balances..xes"})
+		assert.NotNil(t, err)
+		err = store.Save("node1", NodeConfig{AddressPath: "wallet."})
+		assert.NotNil(t, err)
+	})
+}
+
+func TestNodeConfig(t *testing.T) {
+	config := NodeConfig{
+		Tokens:       []string{"XES"},
+		OutputFields: map[string]string{"XES": "xesBalance"},
+	}
+
+	assert.True(t, config.IncludesToken("XES"))
+	assert.False(t, config.IncludesToken("MKR"))
+	assert.True(t, (&NodeConfig{}).IncludesToken("MKR"))
+
+	assert.Equal(t, "xesBalance", config.OutputField("XES"))
+	assert.Equal(t, "balanceBlockNumber", config.OutputField("balanceBlockNumber"))
+
+	assert.Equal(t, []string{"ethAddress"}, config.InputAddressPaths())
+	assert.Equal(t, "balanceDate", config.InputBalanceDatePath())
+	config.AddressPath = "customer.wallet"
+	assert.Equal(t, []string{"customer.wallet"}, config.InputAddressPaths())
+	config.AddressPaths = []string{"wallet1", "wallet2"}
+	assert.Equal(t, []string{"wallet1", "wallet2"}, config.InputAddressPaths())
+}
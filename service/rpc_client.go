@@ -0,0 +1,77 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+type (
+	// Block header with the hash returned by the node. The types.Header of go-ethereum v1.9.11 doesn't know the fields
+	// added since London (e.g. baseFeePerGas), the hash it computes is wrong for newer blocks.
+	BlockHeader struct {
+		*types.Header
+		Hash common.Hash
+	}
+
+	// ethclient.Client retrieving the block headers with their hash
+	rpcEthereumClient struct {
+		*ethclient.Client
+		rpcClient *rpc.Client
+	}
+)
+
+func DialEthereumClient(ctx context.Context, rawurl string) (*rpcEthereumClient, error) {
+	rpcClient, err := rpc.DialContext(ctx, rawurl)
+	if err != nil {
+		return nil, err
+	}
+	return &rpcEthereumClient{Client: ethclient.NewClient(rpcClient), rpcClient: rpcClient}, nil
+}
+
+// Header of the latest block if number is nil, ethereum.NotFound if the block doesn't exist
+func (me *rpcEthereumClient) BlockHeaderByNumber(ctx context.Context, number *big.Int) (*BlockHeader, error) {
+	blockNumber := "latest"
+	if number != nil {
+		blockNumber = hexutil.EncodeBig(number)
+	}
+
+	var raw json.RawMessage
+	err := me.rpcClient.CallContext(ctx, &raw, "eth_getBlockByNumber", blockNumber, false)
+	if err != nil {
+		return nil, err
+	}
+	return parseBlockHeader(raw)
+}
+
+func parseBlockHeader(raw json.RawMessage) (*BlockHeader, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, ethereum.NotFound
+	}
+
+	header := &types.Header{}
+	err := json.Unmarshal(raw, header)
+	if err != nil {
+		return nil, fmt.Errorf("decoding block header. error: %v", err)
+	}
+	var block struct {
+		Hash *common.Hash `json:"hash"`
+	}
+	err = json.Unmarshal(raw, &block)
+	if err != nil {
+		return nil, fmt.Errorf("decoding block hash. error: %v", err)
+	}
+	if block.Hash == nil {
+		return nil, fmt.Errorf("missing hash of block %s", header.Number)
+	}
+
+	return &BlockHeader{Header: header, Hash: *block.Hash}, nil
+}
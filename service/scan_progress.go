@@ -0,0 +1,61 @@
+package service
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+type (
+	// Progress of a scan of the "Transfer" logs. A request can run several scans, possibly concurrently (one per chain,
+	// per address or per batch of the transfer index), each one reporting its own progress.
+	ScanProgress struct {
+		ScanID        uint64
+		ScannedBlocks uint64
+		TotalBlocks   uint64
+		ScannedChunks int
+		TotalChunks   int
+		// Range of the chunk that was just scanned, chunks complete in any order
+		FromBlock uint64
+		ToBlock   uint64
+		// "Transfer" logs handled so far, including the ones of other tokens or addresses
+		ProcessedLogs uint64
+		// Estimated from the speed of the scan so far, 0 until the first chunk is scanned and once the scan is done
+		ETA time.Duration
+	}
+
+	// Called every time a scan progresses, possibly concurrently
+	ScanProgressFunc func(progress ScanProgress)
+
+	scanProgressKey struct{}
+)
+
+var lastScanID uint64
+
+// Returns a context reporting the progress of the scans run with it to progressFunc
+func WithScanProgress(ctx context.Context, progressFunc ScanProgressFunc) context.Context {
+	return context.WithValue(ctx, scanProgressKey{}, progressFunc)
+}
+
+// Returns the function reporting the progress of a new scan, doing nothing if ctx doesn't report progress. The ScanID
+// of the progress is set by the returned function.
+func newScanProgressFunc(ctx context.Context) ScanProgressFunc {
+	progressFunc, ok := ctx.Value(scanProgressKey{}).(ScanProgressFunc)
+	if !ok {
+		return func(ScanProgress) {}
+	}
+
+	scanID := atomic.AddUint64(&lastScanID, 1)
+	return func(progress ScanProgress) {
+		progress.ScanID = scanID
+		progressFunc(progress)
+	}
+}
+
+// Time left to scan remainingBlocks at the speed scannedBlocks were scanned in elapsed
+func estimateScanTime(elapsed time.Duration, scannedBlocks uint64, remainingBlocks uint64) time.Duration {
+	if scannedBlocks == 0 || remainingBlocks == 0 {
+		return 0
+	}
+	return time.Duration(float64(elapsed) / float64(scannedBlocks) * float64(remainingBlocks))
+}
@@ -0,0 +1,160 @@
+package service
+
+import (
+	"fmt"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type (
+	// Exact amount of a token: Value base units (wei for ETH), 1 token being 10^Decimals base units
+	TokenAmount struct {
+		Value    *big.Int
+		Decimals uint8
+		// Nil if the balance service doesn't know the price of the token
+		Price *TokenPrice
+	}
+
+	// How TokenAmount.Format rounds the digits beyond the scale
+	RoundingMode int
+)
+
+const (
+	RoundHalfUp   RoundingMode = iota // Away from zero if the dropped digits are at least half a unit
+	RoundHalfEven                     // To the even neighbour if the dropped digits are exactly half a unit (bankers' rounding)
+	RoundDown                         // Towards zero, dropped digits are truncated
+	RoundUp                           // Away from zero if any dropped digit isn't zero
+)
+
+// Largest exponent accepted by ParseTokenAmount, a larger one would take a huge amount of memory to expand
+const maxAmountExponent = 1000
+
+// Decimal number as written in JSON: optional sign, integer part, optional fractional part and exponent
+var decimalNumberRegexp = regexp.MustCompile(`^(-?)([0-9]+)(?:\.([0-9]+))?(?:[eE]([+-]?[0-9]+))?$`)
+
+var roundingModesNames = map[string]RoundingMode{
+	"half-up":   RoundHalfUp,
+	"half-even": RoundHalfEven,
+	"down":      RoundDown,
+	"up":        RoundUp,
+}
+
+func ParseRoundingMode(name string) (RoundingMode, error) {
+	roundingMode, ok := roundingModesNames[name]
+	if !ok {
+		return 0, fmt.Errorf("unknown rounding mode %q, expected one of half-up, half-even, down or up", name)
+	}
+	return roundingMode, nil
+}
+
+// Parses an amount in tokens written as a decimal number, possibly in scientific notation like JSON numbers (e.g.
+// "1.5", "5.483e+21"), without any loss of precision. Fractional digits beyond decimals, smaller than a base unit, are
+// rounded with roundingMode.
+func ParseTokenAmount(amount string, decimals uint8, roundingMode RoundingMode) (*TokenAmount, error) {
+	parts := decimalNumberRegexp.FindStringSubmatch(amount)
+	if parts == nil {
+		return nil, fmt.Errorf("invalid amount %q", amount)
+	}
+	sign, integerPart, fractionalPart, exponentPart := parts[1], parts[2], parts[3], parts[4]
+
+	exponent := 0
+	if len(exponentPart) != 0 {
+		var err error
+		exponent, err = strconv.Atoi(exponentPart)
+		if err != nil || exponent > maxAmountExponent || exponent < -maxAmountExponent {
+			return nil, fmt.Errorf("invalid exponent of amount %q", amount)
+		}
+	}
+
+	// amount = digits * 10^(exponent - len(fractionalPart)), shifted by decimals to get base units
+	value, _ := new(big.Int).SetString(integerPart+fractionalPart, 10)
+	if sign == "-" {
+		value.Neg(value)
+	}
+	shift := exponent - len(fractionalPart) + int(decimals)
+	if shift >= 0 {
+		value.Mul(value, pow10(shift))
+	} else {
+		value = roundQuo(value, pow10(-shift), roundingMode)
+	}
+
+	return &TokenAmount{Value: value, Decimals: decimals}, nil
+}
+
+// Approximation of the amount in tokens, only meant for computations. Use Format to display amounts.
+func (me *TokenAmount) Float() *big.Float {
+	val, ok := big.NewFloat(0).SetString(me.Value.String())
+	if !ok {
+		return big.NewFloat(0)
+	}
+	return big.NewFloat(0).Quo(val, new(big.Float).SetInt(pow10(int(me.Decimals))))
+}
+
+// Formats the amount in tokens as a plain decimal string (no exponent) with scale fractional digits, rounded with
+// roundingMode. A negative scale means all the decimals, without trailing zeros: the exact amount.
+func (me *TokenAmount) Format(scale int, roundingMode RoundingMode) string {
+	decimals := int(me.Decimals)
+	value := me.Value
+	exact := scale < 0
+	if exact {
+		scale = decimals
+	}
+	if scale < decimals {
+		value = roundQuo(value, pow10(decimals-scale), roundingMode)
+	} else {
+		value = new(big.Int).Mul(value, pow10(scale-decimals))
+	}
+
+	digits := new(big.Int).Abs(value).String()
+	if len(digits) <= scale {
+		digits = strings.Repeat("0", scale-len(digits)+1) + digits
+	}
+	integerPart, fractionalPart := digits[:len(digits)-scale], digits[len(digits)-scale:]
+	if exact {
+		fractionalPart = strings.TrimRight(fractionalPart, "0")
+	}
+
+	formatted := integerPart
+	if len(fractionalPart) != 0 {
+		formatted += "." + fractionalPart
+	}
+	if value.Sign() < 0 {
+		formatted = "-" + formatted
+	}
+	return formatted
+}
+
+func (me *TokenAmount) String() string {
+	return me.Format(-1, RoundHalfUp)
+}
+
+// Divides value by divisor, rounding the quotient with roundingMode
+func roundQuo(value *big.Int, divisor *big.Int, roundingMode RoundingMode) *big.Int {
+	quotient, remainder := new(big.Int).QuoRem(value, divisor, new(big.Int))
+	if remainder.Sign() == 0 {
+		return quotient
+	}
+
+	// Compare twice the remainder with the divisor to know if the dropped digits are below, at or above a half unit
+	half := new(big.Int).Mul(new(big.Int).Abs(remainder), big.NewInt(2)).Cmp(divisor)
+	awayFromZero := false
+	switch roundingMode {
+	case RoundHalfUp:
+		awayFromZero = half >= 0
+	case RoundHalfEven:
+		awayFromZero = half > 0 || (half == 0 && quotient.Bit(0) == 1)
+	case RoundUp:
+		awayFromZero = true
+	}
+
+	if awayFromZero {
+		quotient.Add(quotient, big.NewInt(int64(value.Sign())))
+	}
+	return quotient
+}
+
+func pow10(exponent int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(exponent)), nil)
+}
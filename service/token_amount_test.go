@@ -0,0 +1,100 @@
+package service
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenAmount_Format(t *testing.T) {
+	largeBalance, _ := new(big.Int).SetString("123456789012345678901234567890123456789", 10)
+
+	tests := []struct {
+		name         string
+		value        *big.Int
+		decimals     uint8
+		scale        int
+		roundingMode RoundingMode
+		expected     string
+	}{
+		{"exact", big.NewInt(10000000000000000), 18, -1, RoundHalfUp, "0.01"},
+		{"exact integer", big.NewInt(2000000), 6, -1, RoundHalfUp, "2"},
+		{"exact zero", big.NewInt(0), 18, -1, RoundHalfUp, "0"},
+		{"exact large balance", largeBalance, 18, -1, RoundHalfUp, "123456789012345678901.234567890123456789"},
+		{"no decimals", big.NewInt(42), 0, -1, RoundHalfUp, "42"},
+		{"padded scale", big.NewInt(25), 1, 4, RoundHalfUp, "2.5000"},
+		{"half up", big.NewInt(12345), 4, 3, RoundHalfUp, "1.235"},
+		{"half up below half", big.NewInt(12344), 4, 3, RoundHalfUp, "1.234"},
+		{"half even down", big.NewInt(12345), 4, 3, RoundHalfEven, "1.234"},
+		{"half even up", big.NewInt(12355), 4, 3, RoundHalfEven, "1.236"},
+		{"half even above half", big.NewInt(123451), 5, 3, RoundHalfEven, "1.235"},
+		{"down", big.NewInt(19999), 4, 2, RoundDown, "1.99"},
+		{"up", big.NewInt(10001), 4, 2, RoundUp, "1.01"},
+		{"scale 0", big.NewInt(15), 1, 0, RoundHalfUp, "2"},
+		{"below smallest unit", big.NewInt(4), 6, 2, RoundHalfUp, "0.00"},
+		{"negative", big.NewInt(-12345), 4, 3, RoundHalfUp, "-1.235"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			amount := &TokenAmount{Value: test.value, Decimals: test.decimals}
+			assert.Equal(t, test.expected, amount.Format(test.scale, test.roundingMode))
+		})
+	}
+}
+
+func TestTokenAmount_Float(t *testing.T) {
+	amount := &TokenAmount{Value: big.NewInt(10000000000000000), Decimals: 18}
+	assert.Equal(t, 0, amount.Float().Cmp(big.NewFloat(0.01)))
+	assert.Equal(t, "0.01", amount.Float().String())
+}
+
+func TestParseRoundingMode(t *testing.T) {
+	roundingMode, err := ParseRoundingMode("half-even")
+	assert.Nil(t, err)
+	assert.Equal(t, RoundHalfEven, roundingMode)
+
+	_, err = ParseRoundingMode("ceiling")
+	assert.NotNil(t, err)
+}
+
+func TestParseTokenAmount(t *testing.T) {
+	tests := []struct {
+		name         string
+		amount       string
+		decimals     uint8
+		roundingMode RoundingMode
+		expected     string // Base units, empty if parsing fails
+	}{
+		{"integer", "2", 6, RoundHalfUp, "2000000"},
+		{"fraction", "1.5", 18, RoundHalfUp, "1500000000000000000"},
+		{"zero", "0", 18, RoundHalfUp, "0"},
+		{"large", "123456789012345678901.234567890123456789", 18, RoundHalfUp, "123456789012345678901234567890123456789"},
+		{"large exponent", "5.483e+21", 0, RoundHalfUp, "5483000000000000000000"},
+		{"upper case exponent", "1E3", 0, RoundHalfUp, "1000"},
+		{"smallest unit", "0.000000000000000001", 18, RoundHalfUp, "1"},
+		{"tiny exponent", "1e-18", 18, RoundHalfUp, "1"},
+		{"below smallest unit half up", "5e-19", 18, RoundHalfUp, "1"},
+		{"below smallest unit down", "5e-19", 18, RoundDown, "0"},
+		{"below half a smallest unit", "1.5e-19", 18, RoundHalfUp, "0"},
+		{"negative half even", "-1.25", 1, RoundHalfEven, "-12"},
+		{"not a number", "abc", 18, RoundHalfUp, ""},
+		{"missing fraction", "1.", 18, RoundHalfUp, ""},
+		{"missing integer", ".5", 18, RoundHalfUp, ""},
+		{"huge exponent", "1e999999999", 18, RoundHalfUp, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			amount, err := ParseTokenAmount(test.amount, test.decimals, test.roundingMode)
+			if len(test.expected) == 0 {
+				assert.NotNil(t, err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, test.expected, amount.Value.String())
+			assert.Equal(t, test.decimals, amount.Decimals)
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/ProxeusApp/node-balance-retriever/blockchain"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+type (
+	TokenDecimalsResolver interface {
+		// Returns the number of decimals of the token with the given symbol: a balance of 1 token is 10^decimals
+		TokenDecimals(ctx context.Context, symbol string) (uint8, error)
+	}
+
+	// Calls the ERC20 "decimals" method of the token contracts. Decimals never change, they're only retrieved once.
+	contractTokenDecimalsResolver struct {
+		ethClient        EthereumClient
+		symbolsContracts map[string]common.Address
+		erc20            abi.ABI
+		decimals         map[string]uint8
+		decimalsLock     sync.RWMutex
+	}
+)
+
+// Decimals of ether
+const ethDecimals = 18
+
+var errUnknownTokenDecimals = errors.New("unknown token decimals")
+
+// configuredDecimals take precedence over the "decimals" method, some contracts don't implement it (it's optional in
+// ERC20). It can be nil.
+func NewContractTokenDecimalsResolver(ethClient EthereumClient, contractTokensMap map[string]string, configuredDecimals map[string]uint8) (*contractTokenDecimalsResolver, error) {
+	erc20, err := abi.JSON(strings.NewReader(blockchain.ERC20ABI))
+	if err != nil {
+		return nil, err
+	}
+
+	symbolsContracts := make(map[string]common.Address, len(contractTokensMap))
+	for contract, symbol := range contractTokensMap {
+		symbolsContracts[symbol] = common.HexToAddress(contract)
+	}
+
+	decimals := make(map[string]uint8, len(configuredDecimals))
+	for symbol, tokenDecimals := range configuredDecimals {
+		decimals[symbol] = tokenDecimals
+	}
+
+	return &contractTokenDecimalsResolver{
+		ethClient:        ethClient,
+		symbolsContracts: symbolsContracts,
+		erc20:            erc20,
+		decimals:         decimals,
+	}, nil
+}
+
+func (me *contractTokenDecimalsResolver) TokenDecimals(ctx context.Context, symbol string) (uint8, error) {
+	me.decimalsLock.RLock()
+	tokenDecimals, ok := me.decimals[symbol]
+	me.decimalsLock.RUnlock()
+	if ok {
+		return tokenDecimals, nil
+	}
+
+	contract, ok := me.symbolsContracts[symbol]
+	if !ok {
+		return 0, errUnknownTokenDecimals
+	}
+
+	input, err := me.erc20.Pack("decimals")
+	if err != nil {
+		return 0, err
+	}
+
+	output, err := me.ethClient.CallContract(ctx, ethereum.CallMsg{To: &contract, Data: input}, nil)
+	if err != nil {
+		return 0, fmt.Errorf("retrieving %s decimals. error: %v", symbol, err)
+	}
+	if len(output) == 0 {
+		return 0, fmt.Errorf("no contract at %s", contract.Hex())
+	}
+
+	err = me.erc20.Unpack(&tokenDecimals, "decimals", output)
+	if err != nil {
+		return 0, fmt.Errorf("unpacking 'decimals' of %s. error: %v", contract.Hex(), err)
+	}
+
+	me.decimalsLock.Lock()
+	me.decimals[symbol] = tokenDecimals
+	me.decimalsLock.Unlock()
+
+	return tokenDecimals, nil
+}
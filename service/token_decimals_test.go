@@ -0,0 +1,40 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContractTokenDecimalsResolver_TokenDecimals(t *testing.T) {
+	ctx := context.Background()
+	resolver, err := NewContractTokenDecimalsResolver(NewEthClientStub(), map[string]string{
+		"0xA017ac5faC5941f95010b12570B812C974469c2C": "XES",
+		"0x1217AC5fAC5941F95010B12570b812c974469c98": "CODE",
+		"0x9f8F72aA9304c8B593d555F12eF6589cC3A579A2": "MKR",
+	}, map[string]uint8{"MKR": 6})
+	assert.Nil(t, err)
+
+	t.Run("contract decimals", func(t *testing.T) {
+		decimals, err := resolver.TokenDecimals(ctx, "XES")
+		assert.Nil(t, err)
+		assert.Equal(t, uint8(18), decimals)
+	})
+
+	t.Run("configured decimals", func(t *testing.T) {
+		decimals, err := resolver.TokenDecimals(ctx, "MKR")
+		assert.Nil(t, err)
+		assert.Equal(t, uint8(6), decimals)
+	})
+
+	t.Run("no contract", func(t *testing.T) {
+		_, err := resolver.TokenDecimals(ctx, "CODE")
+		assert.NotNil(t, err)
+	})
+
+	t.Run("unknown token", func(t *testing.T) {
+		_, err := resolver.TokenDecimals(ctx, "ANY")
+		assert.Equal(t, errUnknownTokenDecimals, err)
+	})
+}
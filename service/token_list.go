@@ -0,0 +1,67 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+type (
+	// Token list following the https://tokenlists.org standard
+	tokenList struct {
+		Name   string           `json:"name"`
+		Tokens []tokenListToken `json:"tokens"`
+	}
+
+	tokenListToken struct {
+		ChainID  uint64 `json:"chainId"`
+		Address  string `json:"address"`
+		Name     string `json:"name"`
+		Symbol   string `json:"symbol"`
+		Decimals *uint8 `json:"decimals"`
+		LogoURI  string `json:"logoURI"`
+	}
+)
+
+// Loads the tokens of chainID from a token list file, as tokens of network. Tokens of other chains are ignored.
+func LoadTokenList(path string, chainID uint64, network string) (*TokenRegistry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	list := tokenList{}
+	err = json.NewDecoder(file).Decode(&list)
+	if err != nil {
+		return nil, fmt.Errorf("decoding token list %s. error: %v", path, err)
+	}
+
+	registry := &TokenRegistry{}
+	for _, token := range list.Tokens {
+		if token.ChainID != chainID {
+			continue
+		}
+		if !common.IsHexAddress(token.Address) {
+			return nil, fmt.Errorf("token %s of token list %s has an invalid address %q", token.Symbol, path, token.Address)
+		}
+
+		registry.Tokens = append(registry.Tokens, RegistryToken{
+			Symbol:   token.Symbol,
+			Address:  common.HexToAddress(token.Address).Hex(),
+			Decimals: token.Decimals,
+			Network:  network,
+			Name:     token.Name,
+			LogoURI:  token.LogoURI,
+		})
+	}
+
+	err = registry.validate()
+	if err != nil {
+		return nil, fmt.Errorf("invalid token list %s. error: %v", path, err)
+	}
+
+	return registry, nil
+}
@@ -0,0 +1,70 @@
+package service
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadTokenList(t *testing.T) {
+	dir, err := ioutil.TempDir("", "token-list")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	writeTokenList := func(content string) string {
+		path := filepath.Join(dir, "tokenlist.json")
+		assert.Nil(t, ioutil.WriteFile(path, []byte(content), 0644))
+		return path
+	}
+
+	t.Run("filtered by chain", func(t *testing.T) {
+		registry, err := LoadTokenList(writeTokenList(`{"name": "Test List", "timestamp": "2020-06-12T00:00:00+00:00", "version": {"major": 1, "minor": 0, "patch": 0}, "tokens": [
+			{"chainId": 1, "address": "0xa0b86991c6218b36c1d19d4a2e9eb0ce3606eb48", "name": "USD Coin", "symbol": "USDC", "decimals": 6, "logoURI": "https://example.com/usdc.png"},
+			{"chainId": 1, "address": "0x9f8F72aA9304c8B593d555F12eF6589cC3A579A2", "name": "Maker", "symbol": "MKR", "decimals": 18},
+			{"chainId": 137, "address": "0x2791Bca1f2de4661ED88A30C99A7a9449Aa84174", "name": "USD Coin", "symbol": "USDC", "decimals": 6}
+		]}`), 1, "mainnet")
+		assert.Nil(t, err)
+
+		assert.Equal(t, map[string]string{
+			"0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48": "USDC",
+			"0x9f8F72aA9304c8B593d555F12eF6589cC3A579A2": "MKR",
+		}, registry.TokensMap("mainnet"))
+		assert.Equal(t, map[string]uint8{"USDC": 6, "MKR": 18}, registry.Decimals("mainnet"))
+		assert.Equal(t, "https://example.com/usdc.png", registry.Tokens[0].LogoURI)
+		assert.Equal(t, "USD Coin", registry.Tokens[0].Name)
+	})
+
+	t.Run("duplicate address", func(t *testing.T) {
+		_, err := LoadTokenList(writeTokenList(`{"name": "Test List", "tokens": [
+			{"chainId": 1, "address": "0xa0b86991c6218b36c1d19d4a2e9eb0ce3606eb48", "name": "USD Coin", "symbol": "USDC", "decimals": 6},
+			{"chainId": 1, "address": "0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48", "name": "USD Coin (old)", "symbol": "USDC.e", "decimals": 6}
+		]}`), 1, "mainnet")
+		assert.NotNil(t, err)
+	})
+
+	t.Run("duplicate symbol", func(t *testing.T) {
+		_, err := LoadTokenList(writeTokenList(`{"name": "Test List", "tokens": [
+			{"chainId": 1, "address": "0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48", "name": "USD Coin", "symbol": "USDC", "decimals": 6},
+			{"chainId": 1, "address": "0x9f8F72aA9304c8B593d555F12eF6589cC3A579A2", "name": "Fake USD Coin", "symbol": "USDC", "decimals": 6}
+		]}`), 1, "mainnet")
+		assert.NotNil(t, err)
+	})
+
+	t.Run("merged into a token registry", func(t *testing.T) {
+		registry := &TokenRegistry{Tokens: []RegistryToken{
+			{Symbol: "XES", Address: "0xA017ac5faC5941f95010b12570B812C974469c2C", Network: "mainnet"},
+		}}
+		tokenList, err := LoadTokenList(writeTokenList(`{"name": "Test List", "tokens": [
+			{"chainId": 1, "address": "0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48", "name": "USD Coin", "symbol": "USDC", "decimals": 6}
+		]}`), 1, "mainnet")
+		assert.Nil(t, err)
+
+		assert.Nil(t, registry.Add(tokenList))
+		assert.Len(t, registry.TokensMap("mainnet"), 2)
+		assert.NotNil(t, registry.Add(tokenList))
+		assert.Len(t, registry.TokensMap("mainnet"), 2)
+	})
+}
@@ -0,0 +1,100 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"time"
+)
+
+type (
+	// Price of one token (not one base unit) in a fiat currency
+	TokenPrice struct {
+		Rate     *big.Rat
+		Currency string
+		// When the rate was observed by the price source
+		Timestamp time.Time
+	}
+
+	// Source of historical token prices: a rate sheet, a price API, on-chain price feeds,..
+	PriceOracle interface {
+		// Returns the last price of one token in currency (ISO 4217 code, e.g. CHF) known at date, the latest price if
+		// date is zero. Returns errUnknownTokenPrice if the oracle has no price for the token in currency at date.
+		Price(ctx context.Context, symbol, currency string, date time.Time) (*TokenPrice, error)
+	}
+
+	// Derives the prices missing from an oracle from the prices of the token and of the currency in another currency,
+	// e.g. ETH in CHF from ETH in USD and CHF in USD
+	crossRatePriceOracle struct {
+		oracle        PriceOracle
+		crossCurrency string
+	}
+)
+
+// Decimals of the fiat values returned by TokenPrice.Value, rounded when formatted
+const fiatValueDecimals = 18
+
+var errUnknownTokenPrice = errors.New("unknown token price")
+
+// Value of amount in the currency of the price, rounded half-up to fiatValueDecimals decimals
+func (me *TokenPrice) Value(amount *TokenAmount) *TokenAmount {
+	// amount.Value / 10^amount.Decimals * Rate, expressed in 10^-fiatValueDecimals units of the currency
+	numerator := new(big.Int).Mul(amount.Value, me.Rate.Num())
+	numerator.Mul(numerator, pow10(fiatValueDecimals))
+	denominator := new(big.Int).Mul(me.Rate.Denom(), pow10(int(amount.Decimals)))
+
+	return &TokenAmount{Value: roundQuo(numerator, denominator, RoundHalfUp), Decimals: fiatValueDecimals}
+}
+
+// Formats the rate as a plain decimal string, exact up to fiatValueDecimals fractional digits
+func (me *TokenPrice) FormatRate() string {
+	return me.Value(&TokenAmount{Value: big.NewInt(1)}).String()
+}
+
+// Parses a rate written as a decimal number, possibly in scientific notation, without loss of precision
+func parseRate(rate string) (*big.Rat, bool) {
+	parts := decimalNumberRegexp.FindStringSubmatch(rate)
+	if parts == nil {
+		return nil, false
+	}
+	if exponent, err := strconv.Atoi(parts[4]); len(parts[4]) != 0 && (err != nil || exponent > maxAmountExponent || exponent < -maxAmountExponent) {
+		return nil, false
+	}
+	return new(big.Rat).SetString(rate)
+}
+
+func NewCrossRatePriceOracle(oracle PriceOracle, crossCurrency string) *crossRatePriceOracle {
+	return &crossRatePriceOracle{oracle: oracle, crossCurrency: crossCurrency}
+}
+
+// The timestamp of a derived price is the oldest of the timestamps of the two prices it's derived from
+func (me *crossRatePriceOracle) Price(ctx context.Context, symbol, currency string, date time.Time) (*TokenPrice, error) {
+	price, err := me.oracle.Price(ctx, symbol, currency, date)
+	if err != errUnknownTokenPrice || symbol == me.crossCurrency || currency == me.crossCurrency {
+		return price, err
+	}
+
+	tokenPrice, err := me.oracle.Price(ctx, symbol, me.crossCurrency, date)
+	if err != nil {
+		return nil, err
+	}
+	currencyPrice, err := me.oracle.Price(ctx, currency, me.crossCurrency, date)
+	if err != nil {
+		return nil, err
+	}
+	if currencyPrice.Rate.Sign() <= 0 {
+		return nil, fmt.Errorf("invalid %s price of %s: %s", me.crossCurrency, currency, currencyPrice.FormatRate())
+	}
+
+	timestamp := tokenPrice.Timestamp
+	if currencyPrice.Timestamp.Before(timestamp) {
+		timestamp = currencyPrice.Timestamp
+	}
+	return &TokenPrice{
+		Rate:      new(big.Rat).Quo(tokenPrice.Rate, currencyPrice.Rate),
+		Currency:  currency,
+		Timestamp: timestamp,
+	}, nil
+}
@@ -0,0 +1,70 @@
+package service
+
+import (
+	"context"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenPrice_Value(t *testing.T) {
+	tests := []struct {
+		name     string
+		rate     string
+		amount   *TokenAmount
+		expected string // Value formatted with 2 decimals
+		exact    string
+	}{
+		{"whole tokens", "1812.34", &TokenAmount{Value: big.NewInt(2000000000000000000), Decimals: 18}, "3624.68", "3624.68"},
+		{"fewer decimals", "0.9998", &TokenAmount{Value: big.NewInt(2500000), Decimals: 6}, "2.50", "2.4995"},
+		{"rounded half-up", "0.005", &TokenAmount{Value: big.NewInt(1), Decimals: 0}, "0.01", "0.005"},
+		{"scientific notation", "4.2e-3", &TokenAmount{Value: big.NewInt(1234500000000000000), Decimals: 18}, "0.01", "0.0051849"},
+		{"negative", "2", &TokenAmount{Value: big.NewInt(-150), Decimals: 2}, "-3.00", "-3"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rate, ok := parseRate(test.rate)
+			assert.True(t, ok)
+			price := &TokenPrice{Rate: rate, Currency: "USD"}
+			value := price.Value(test.amount)
+			assert.Equal(t, test.expected, value.Format(2, RoundHalfUp))
+			assert.Equal(t, test.exact, value.String())
+		})
+	}
+}
+
+func TestTokenPrice_FormatRate(t *testing.T) {
+	rate, ok := parseRate("4.2e-3")
+	assert.True(t, ok)
+	assert.Equal(t, "0.0042", (&TokenPrice{Rate: rate}).FormatRate())
+
+	_, ok = parseRate("1e100000")
+	assert.False(t, ok)
+	_, ok = parseRate("1/3")
+	assert.False(t, ok)
+}
+
+func TestCrossRatePriceOracle_Price(t *testing.T) {
+	date := time.Date(2023, 12, 31, 23, 59, 59, 0, time.UTC)
+	ethUSD := &TokenPrice{Rate: big.NewRat(2000, 1), Currency: "USD", Timestamp: date.Add(-time.Minute)}
+	chfUSD := &TokenPrice{Rate: big.NewRat(125, 100), Currency: "USD", Timestamp: date.Add(-time.Hour)}
+	xesCHF := &TokenPrice{Rate: big.NewRat(1, 100), Currency: "CHF", Timestamp: date}
+	priceOracle := NewCrossRatePriceOracle(priceOracleStub{"ETH/USD": ethUSD, "CHF/USD": chfUSD, "XES/CHF": xesCHF}, "USD")
+
+	price, err := priceOracle.Price(context.Background(), "ETH", "CHF", date)
+	assert.Nil(t, err)
+	assert.Equal(t, "1600", price.FormatRate())
+	assert.Equal(t, "CHF", price.Currency)
+	// The oldest of the two prices
+	assert.Equal(t, chfUSD.Timestamp, price.Timestamp)
+
+	price, err = priceOracle.Price(context.Background(), "XES", "CHF", date)
+	assert.Nil(t, err)
+	assert.Equal(t, xesCHF, price)
+
+	_, err = priceOracle.Price(context.Background(), "XES", "EUR", date)
+	assert.Equal(t, errUnknownTokenPrice, err)
+}
@@ -0,0 +1,152 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/ProxeusApp/node-balance-retriever/blockchain"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+type (
+	// Tokens to retrieve balances for, loaded from a JSON file:
+	//
+	// {
+	//   "tokens": [
+	//     {"symbol": "XES", "address": "0xA017ac5faC5941f95010b12570B812C974469c2C", "decimals": 18, "network": "mainnet", "deploymentBlock": 5066880}
+	//   ]
+	// }
+	TokenRegistry struct {
+		Tokens []RegistryToken `json:"tokens"`
+	}
+
+	RegistryToken struct {
+		Symbol string `json:"symbol"`
+		// EIP-55 checksummed contract address
+		Address string `json:"address"`
+		// Optional, the decimals of the contract are used if missing
+		Decimals *uint8 `json:"decimals,omitempty"`
+		Network  string `json:"network"`
+		// Block the contract was deployed in, optional. There's no "Transfer" event to scan before it.
+		DeploymentBlock *uint64 `json:"deploymentBlock,omitempty"`
+		// Optional metadata
+		Name    string `json:"name,omitempty"`
+		LogoURI string `json:"logoURI,omitempty"`
+	}
+)
+
+func LoadTokenRegistry(path string) (*TokenRegistry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	registry := &TokenRegistry{}
+	decoder := json.NewDecoder(file)
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(registry)
+	if err != nil {
+		return nil, fmt.Errorf("decoding token registry %s. error: %v", path, err)
+	}
+
+	err = registry.validate()
+	if err != nil {
+		return nil, fmt.Errorf("invalid token registry %s. error: %v", path, err)
+	}
+
+	return registry, nil
+}
+
+func (me *TokenRegistry) validate() error {
+	symbols := make(map[string]bool)
+	addresses := make(map[string]bool)
+	for i, token := range me.Tokens {
+		if len(token.Symbol) == 0 {
+			return fmt.Errorf("token %d has no symbol", i)
+		}
+		if len(token.Network) == 0 {
+			return fmt.Errorf("token %s has no network", token.Symbol)
+		}
+		// A misspelled network would silently leave the token out of the balances
+		if _, ok := blockchain.Networks[token.Network]; !ok {
+			return fmt.Errorf("token %s has an unknown network %q", token.Symbol, token.Network)
+		}
+		if !common.IsHexAddress(token.Address) {
+			return fmt.Errorf("token %s has an invalid address %q", token.Symbol, token.Address)
+		}
+		if checksumAddress := common.HexToAddress(token.Address).Hex(); checksumAddress != token.Address {
+			return fmt.Errorf("token %s address %s doesn't match its EIP-55 checksum, expected %s", token.Symbol, token.Address, checksumAddress)
+		}
+
+		// The same token can be listed once per network
+		if symbols[token.Network+":"+token.Symbol] {
+			return fmt.Errorf("token %s is listed twice on network %s", token.Symbol, token.Network)
+		}
+		symbols[token.Network+":"+token.Symbol] = true
+		if addresses[token.Network+":"+token.Address] {
+			return fmt.Errorf("address %s is listed twice on network %s", token.Address, token.Network)
+		}
+		addresses[token.Network+":"+token.Address] = true
+	}
+
+	return nil
+}
+
+// Adds the tokens of other, they must not already be part of the registry
+func (me *TokenRegistry) Add(other *TokenRegistry) error {
+	merged := &TokenRegistry{Tokens: append(append([]RegistryToken{}, me.Tokens...), other.Tokens...)}
+	err := merged.validate()
+	if err != nil {
+		return err
+	}
+
+	me.Tokens = merged.Tokens
+	return nil
+}
+
+func (me *TokenRegistry) NetworkTokens(network string) []RegistryToken {
+	var tokens []RegistryToken
+	for _, token := range me.Tokens {
+		if token.Network == network {
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens
+}
+
+// Contract address -> symbol of the tokens of network, as expected by the EthBalanceService implementations
+func (me *TokenRegistry) TokensMap(network string) map[string]string {
+	tokensMap := make(map[string]string)
+	for _, token := range me.NetworkTokens(network) {
+		tokensMap[token.Address] = token.Symbol
+	}
+	return tokensMap
+}
+
+// Symbol -> decimals of the tokens of network, only for the tokens with decimals
+func (me *TokenRegistry) Decimals(network string) map[string]uint8 {
+	decimals := make(map[string]uint8)
+	for _, token := range me.NetworkTokens(network) {
+		if token.Decimals != nil {
+			decimals[token.Symbol] = *token.Decimals
+		}
+	}
+	return decimals
+}
+
+// First block that can contain a "Transfer" event of one of the tokens of network: the earliest deployment block, 0 if
+// the deployment block of a token is unknown.
+func (me *TokenRegistry) StartBlock(network string) uint64 {
+	var startBlock uint64
+	for i, token := range me.NetworkTokens(network) {
+		if token.DeploymentBlock == nil {
+			return 0
+		}
+		if i == 0 || *token.DeploymentBlock < startBlock {
+			startBlock = *token.DeploymentBlock
+		}
+	}
+	return startBlock
+}
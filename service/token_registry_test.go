@@ -0,0 +1,86 @@
+package service
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadTokenRegistry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "token-registry")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	writeRegistry := func(content string) string {
+		path := filepath.Join(dir, "tokens.json")
+		assert.Nil(t, ioutil.WriteFile(path, []byte(content), 0644))
+		return path
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		registry, err := LoadTokenRegistry(writeRegistry(`{"tokens": [
+			{"symbol": "XES", "address": "0xA017ac5faC5941f95010b12570B812C974469c2C", "decimals": 18, "network": "mainnet", "deploymentBlock": 5000000},
+			{"symbol": "USDC", "address": "0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48", "decimals": 6, "network": "mainnet", "deploymentBlock": 6082465},
+			{"symbol": "USDC", "address": "0x1c7D4B196Cb0C7B01d743Fbc6116a902379C7238", "decimals": 6, "network": "sepolia"}
+		]}`))
+		assert.Nil(t, err)
+
+		assert.Equal(t, map[string]string{
+			"0xA017ac5faC5941f95010b12570B812C974469c2C": "XES",
+			"0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48": "USDC",
+		}, registry.TokensMap("mainnet"))
+		assert.Equal(t, map[string]uint8{"XES": 18, "USDC": 6}, registry.Decimals("mainnet"))
+		assert.Equal(t, uint64(5000000), registry.StartBlock("mainnet"))
+		assert.Equal(t, uint64(0), registry.StartBlock("sepolia"))
+		assert.Len(t, registry.NetworkTokens("goerli"), 0)
+	})
+
+	t.Run("missing decimals", func(t *testing.T) {
+		registry, err := LoadTokenRegistry(writeRegistry(`{"tokens": [
+			{"symbol": "XES", "address": "0xA017ac5faC5941f95010b12570B812C974469c2C", "decimals": 18, "network": "mainnet"},
+			{"symbol": "USDC", "address": "0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48", "network": "mainnet"}
+		]}`))
+		assert.Nil(t, err)
+
+		// The decimals of the USDC contract apply
+		assert.Equal(t, map[string]uint8{"XES": 18}, registry.Decimals("mainnet"))
+	})
+
+	t.Run("invalid checksum", func(t *testing.T) {
+		_, err := LoadTokenRegistry(writeRegistry(`{"tokens": [
+			{"symbol": "XES", "address": "0xa017ac5fac5941f95010b12570b812c974469c2c", "decimals": 18, "network": "mainnet"}
+		]}`))
+		assert.NotNil(t, err)
+	})
+
+	t.Run("duplicate symbol", func(t *testing.T) {
+		_, err := LoadTokenRegistry(writeRegistry(`{"tokens": [
+			{"symbol": "XES", "address": "0xA017ac5faC5941f95010b12570B812C974469c2C", "decimals": 18, "network": "mainnet"},
+			{"symbol": "XES", "address": "0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48", "decimals": 6, "network": "mainnet"}
+		]}`))
+		assert.NotNil(t, err)
+	})
+
+	t.Run("missing network", func(t *testing.T) {
+		_, err := LoadTokenRegistry(writeRegistry(`{"tokens": [
+			{"symbol": "XES", "address": "0xA017ac5faC5941f95010b12570B812C974469c2C", "decimals": 18}
+		]}`))
+		assert.NotNil(t, err)
+	})
+
+	t.Run("unknown network", func(t *testing.T) {
+		_, err := LoadTokenRegistry(writeRegistry(`{"tokens": [
+			{"symbol": "USDC", "address": "0x3c499c542cEF5E3811e1192ce70d8cC03d5c3359", "decimals": 6, "network": "polgyon"}
+		]}`))
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), `token USDC has an unknown network "polgyon"`)
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		_, err := LoadTokenRegistry(filepath.Join(dir, "missing.json"))
+		assert.NotNil(t, err)
+	})
+}
@@ -0,0 +1,342 @@
+package service
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+type (
+	// On-disk index of the ERC20 "Transfer" events of the tracked contracts, stored in a bbolt database. Transfers are
+	// stored under their contract and both their sender and receiver, so the balance of an address only reads the
+	// transfers of that address. Every update is a single transaction: the index never holds transfers of blocks that
+	// aren't marked as indexed.
+	// The hashes of the last indexed blocks are kept as well, to detect chain reorganisations.
+	transferIndex struct {
+		db *bolt.DB
+	}
+
+	indexedTransfer struct {
+		Contract    string   `json:"contract"`
+		BlockNumber uint64   `json:"blockNumber"`
+		TxHash      string   `json:"txHash"`
+		LogIndex    uint     `json:"logIndex"`
+		From        string   `json:"from"`
+		To          string   `json:"to"`
+		Value       *big.Int `json:"value"`
+	}
+
+	transferIndexCheckpoint struct {
+		blockNumber uint64
+		blockHash   string
+	}
+)
+
+const (
+	transferIndexFile = "transfers.db"
+
+	// Amount of block hashes kept to find the last block that is still part of the chain after a reorganisation
+	transferIndexMaxCheckpoints = 128
+)
+
+var (
+	// contract address (EIP-55) -> last indexed block
+	lastIndexedBlocksBucket = []byte("lastIndexedBlocks")
+	// indexed block number -> block hash
+	blockHashesBucket = []byte("blockHashes")
+	// contract address -> holder address -> transfer key -> transfer, see transferKey
+	holderTransfersBucket = []byte("holderTransfers")
+	// transfer key -> transfer, to find the transfers of the blocks to roll back
+	blockTransfersBucket = []byte("blockTransfers")
+
+	transferIndexBuckets = [][]byte{lastIndexedBlocksBucket, blockHashesBucket, holderTransfersBucket, blockTransfersBucket}
+)
+
+// Opens the transfer index stored in dir, creating it if it doesn't exist yet. Only one process can open it at once.
+func NewTransferIndex(dir string) (*transferIndex, error) {
+	err := os.MkdirAll(dir, 0750)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := bolt.Open(filepath.Join(dir, transferIndexFile), 0640, &bolt.Options{Timeout: time.Second * 10})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range transferIndexBuckets {
+			_, err := tx.CreateBucketIfNotExists(bucket)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &transferIndex{db: db}, nil
+}
+
+func (me *transferIndex) Close() error {
+	return me.db.Close()
+}
+
+// Returns the first block that still has to be indexed for at least one of the contracts
+func (me *transferIndex) nextBlockToIndex(contracts []string) uint64 {
+	var nextBlock uint64
+	me.db.View(func(tx *bolt.Tx) error {
+		lastIndexedBlocks := tx.Bucket(lastIndexedBlocksBucket)
+		for i, contract := range contracts {
+			lastIndexedBlock := lastIndexedBlocks.Get([]byte(contract))
+			if lastIndexedBlock == nil {
+				nextBlock = 0
+				return nil
+			}
+			if i == 0 || binary.BigEndian.Uint64(lastIndexedBlock)+1 < nextBlock {
+				nextBlock = binary.BigEndian.Uint64(lastIndexedBlock) + 1
+			}
+		}
+		return nil
+	})
+
+	return nextBlock
+}
+
+// Stores the transfers and marks contracts as indexed up to toBlock. Transfers that are already part of the index
+// are skipped, so the same block range can safely be added twice.
+func (me *transferIndex) add(transfers []indexedTransfer, contracts []string, toBlock uint64, toBlockHash string) error {
+	return me.db.Update(func(tx *bolt.Tx) error {
+		lastIndexedBlocks := tx.Bucket(lastIndexedBlocksBucket)
+		for _, transfer := range transfers {
+			lastIndexedBlock := lastIndexedBlocks.Get([]byte(transfer.Contract))
+			if lastIndexedBlock != nil && transfer.BlockNumber <= binary.BigEndian.Uint64(lastIndexedBlock) {
+				continue
+			}
+
+			err := putTransfer(tx, transfer)
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, contract := range contracts {
+			lastIndexedBlock := lastIndexedBlocks.Get([]byte(contract))
+			if lastIndexedBlock == nil || binary.BigEndian.Uint64(lastIndexedBlock) < toBlock {
+				err := lastIndexedBlocks.Put([]byte(contract), uint64Key(toBlock))
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		blockHashes := tx.Bucket(blockHashesBucket)
+		err := blockHashes.Put(uint64Key(toBlock), []byte(toBlockHash))
+		if err != nil {
+			return err
+		}
+
+		// Only keep the most recent checkpoints
+		var oldCheckpoints [][]byte
+		cursor := blockHashes.Cursor()
+		kept := 0
+		for key, _ := cursor.Last(); key != nil; key, _ = cursor.Prev() {
+			kept++
+			if kept > transferIndexMaxCheckpoints {
+				oldCheckpoints = append(oldCheckpoints, append([]byte{}, key...))
+			}
+		}
+		for _, key := range oldCheckpoints {
+			err = blockHashes.Delete(key)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Stores transfer under its sender, its receiver and its block. Storing a transfer twice overwrites it.
+func putTransfer(tx *bolt.Tx, transfer indexedTransfer) error {
+	value, err := json.Marshal(transfer)
+	if err != nil {
+		return err
+	}
+	key := transferKey(transfer)
+
+	contractTransfers, err := tx.Bucket(holderTransfersBucket).CreateBucketIfNotExists([]byte(transfer.Contract))
+	if err != nil {
+		return err
+	}
+	for _, holder := range []string{transfer.From, transfer.To} {
+		holderTransfers, err := contractTransfers.CreateBucketIfNotExists([]byte(holder))
+		if err != nil {
+			return err
+		}
+		err = holderTransfers.Put(key, value)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Bucket(blockTransfersBucket).Put(key, value)
+}
+
+// Returns the indexed blocks whose hash is known, most recent first
+func (me *transferIndex) checkpoints() []transferIndexCheckpoint {
+	checkpoints := []transferIndexCheckpoint{}
+	me.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(blockHashesBucket).Cursor()
+		for key, value := cursor.Last(); key != nil; key, value = cursor.Prev() {
+			checkpoints = append(checkpoints, transferIndexCheckpoint{blockNumber: binary.BigEndian.Uint64(key), blockHash: string(value)})
+		}
+		return nil
+	})
+
+	return checkpoints
+}
+
+// Removes everything indexed after toBlock. Used when the indexed blocks are no longer part of the chain.
+func (me *transferIndex) rollback(toBlock uint64) error {
+	return me.db.Update(func(tx *bolt.Tx) error {
+		// A bucket can't be modified while iterating over it
+		var rolledBackContracts []string
+		lastIndexedBlocks := tx.Bucket(lastIndexedBlocksBucket)
+		lastIndexedBlocks.ForEach(func(contract, lastIndexedBlock []byte) error {
+			if binary.BigEndian.Uint64(lastIndexedBlock) > toBlock {
+				rolledBackContracts = append(rolledBackContracts, string(contract))
+			}
+			return nil
+		})
+		for _, contract := range rolledBackContracts {
+			err := lastIndexedBlocks.Put([]byte(contract), uint64Key(toBlock))
+			if err != nil {
+				return err
+			}
+		}
+
+		err := deleteFrom(tx.Bucket(blockHashesBucket), uint64Key(toBlock+1))
+		if err != nil {
+			return err
+		}
+
+		// Transfer keys start with the block number
+		blockTransfers := tx.Bucket(blockTransfersBucket)
+		cursor := blockTransfers.Cursor()
+		for key, value := cursor.Seek(uint64Key(toBlock + 1)); key != nil; key, value = cursor.Next() {
+			var transfer indexedTransfer
+			err := json.Unmarshal(value, &transfer)
+			if err != nil {
+				return fmt.Errorf("reading transfer index. error: %v", err)
+			}
+
+			contractTransfers := tx.Bucket(holderTransfersBucket).Bucket([]byte(transfer.Contract))
+			if contractTransfers == nil {
+				continue
+			}
+			for _, holder := range []string{transfer.From, transfer.To} {
+				if holderTransfers := contractTransfers.Bucket([]byte(holder)); holderTransfers != nil {
+					err = holderTransfers.Delete(key)
+					if err != nil {
+						return err
+					}
+				}
+			}
+		}
+		return deleteFrom(blockTransfers, uint64Key(toBlock+1))
+	})
+}
+
+// Removes everything from the index, the next update starts over from the genesis block
+func (me *transferIndex) reset() error {
+	return me.db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range transferIndexBuckets {
+			err := tx.DeleteBucket(bucket)
+			if err != nil {
+				return err
+			}
+			_, err = tx.CreateBucket(bucket)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Sums all the transfers from and to address up to (and including) blockNumber
+func (me *transferIndex) balanceAt(contract, address string, blockNumber uint64) (*big.Int, error) {
+	balance := big.NewInt(0)
+	err := me.db.View(func(tx *bolt.Tx) error {
+		contractTransfers := tx.Bucket(holderTransfersBucket).Bucket([]byte(contract))
+		if contractTransfers == nil {
+			return nil
+		}
+		holderTransfers := contractTransfers.Bucket([]byte(address))
+		if holderTransfers == nil {
+			return nil
+		}
+
+		// Transfers are sorted by block
+		cursor := holderTransfers.Cursor()
+		for key, value := cursor.First(); key != nil && binary.BigEndian.Uint64(key) <= blockNumber; key, value = cursor.Next() {
+			var transfer indexedTransfer
+			err := json.Unmarshal(value, &transfer)
+			if err != nil {
+				return fmt.Errorf("reading transfer index. error: %v", err)
+			}
+			if transfer.Value == nil {
+				return errors.New("reading transfer index. error: transfer without value")
+			}
+
+			if transfer.To == address {
+				balance.Add(balance, transfer.Value)
+			}
+			if transfer.From == address {
+				balance.Sub(balance, transfer.Value)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return balance, nil
+}
+
+// Deletes the keys of bucket from fromKey onwards
+func deleteFrom(bucket *bolt.Bucket, fromKey []byte) error {
+	cursor := bucket.Cursor()
+	for key, _ := cursor.Seek(fromKey); key != nil; key, _ = cursor.Seek(fromKey) {
+		err := cursor.Delete()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Block number, then log index, then transaction hash: sorted by block and unique for every event
+func transferKey(transfer indexedTransfer) []byte {
+	key := make([]byte, 16, 16+len(transfer.TxHash))
+	binary.BigEndian.PutUint64(key[:8], transfer.BlockNumber)
+	binary.BigEndian.PutUint64(key[8:], uint64(transfer.LogIndex))
+	return append(key, transfer.TxHash...)
+}
+
+func uint64Key(value uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, value)
+	return key
+}
@@ -0,0 +1,124 @@
+package service
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransferIndex(t *testing.T) {
+	const (
+		contract = "0xA017ac5faC5941f95010b12570B812C974469c2C"
+		holder   = "0x043129ab3945D2bB75f3B5DE21487343EFBeffd2"
+		other    = "0xef91eCD0142aE4C5163b2cF060C0563D49188C82"
+		minter   = "0x0000000000000000000000000000000000000000"
+	)
+
+	indexDir, err := ioutil.TempDir("", "transfer-index")
+	assert.Nil(t, err)
+	defer os.RemoveAll(indexDir)
+
+	transferIndex, err := NewTransferIndex(indexDir)
+	assert.Nil(t, err)
+	defer func() { transferIndex.Close() }()
+	assert.Equal(t, uint64(0), transferIndex.nextBlockToIndex([]string{contract}))
+
+	balanceAt := func(address string, blockNumber uint64) *big.Int {
+		balance, err := transferIndex.balanceAt(contract, address, blockNumber)
+		assert.Nil(t, err)
+		return balance
+	}
+	reopen := func() {
+		assert.Nil(t, transferIndex.Close())
+		transferIndex, err = NewTransferIndex(indexDir)
+		assert.Nil(t, err)
+	}
+
+	transfers := []indexedTransfer{
+		{Contract: contract, BlockNumber: 10, TxHash: "0x01", From: minter, To: holder, Value: big.NewInt(100)},
+		{Contract: contract, BlockNumber: 20, TxHash: "0x02", From: holder, To: other, Value: big.NewInt(30)},
+	}
+	err = transferIndex.add(transfers, []string{contract}, 25, "0x25")
+	assert.Nil(t, err)
+
+	t.Run("balance at block", func(t *testing.T) {
+		assert.Equal(t, big.NewInt(100), balanceAt(holder, 15))
+		assert.Equal(t, big.NewInt(70), balanceAt(holder, 25))
+		assert.Equal(t, big.NewInt(30), balanceAt(other, 25))
+	})
+
+	t.Run("next block to index", func(t *testing.T) {
+		assert.Equal(t, uint64(26), transferIndex.nextBlockToIndex([]string{contract}))
+		assert.Equal(t, uint64(0), transferIndex.nextBlockToIndex([]string{contract, "0x9f8F72aA9304c8B593d555F12eF6589cC3A579A2"}))
+	})
+
+	t.Run("already indexed transfers are skipped", func(t *testing.T) {
+		err := transferIndex.add(transfers, []string{contract}, 25, "0x25")
+
+		assert.Nil(t, err)
+		assert.Equal(t, big.NewInt(70), balanceAt(holder, 25))
+	})
+
+	t.Run("reopen from disk", func(t *testing.T) {
+		reopen()
+
+		assert.Equal(t, uint64(26), transferIndex.nextBlockToIndex([]string{contract}))
+		assert.Equal(t, big.NewInt(70), balanceAt(holder, 25))
+		assert.Equal(t, []transferIndexCheckpoint{{blockNumber: 25, blockHash: "0x25"}}, transferIndex.checkpoints())
+	})
+
+	t.Run("rollback", func(t *testing.T) {
+		err := transferIndex.add(nil, []string{contract}, 40, "0x40")
+		assert.Nil(t, err)
+
+		err = transferIndex.rollback(15)
+		assert.Nil(t, err)
+
+		assert.Equal(t, uint64(16), transferIndex.nextBlockToIndex([]string{contract}))
+		assert.Equal(t, big.NewInt(100), balanceAt(holder, 40))
+		assert.Empty(t, transferIndex.checkpoints())
+
+		reopen()
+		assert.Equal(t, big.NewInt(100), balanceAt(holder, 40))
+		assert.Equal(t, big.NewInt(0), balanceAt(other, 40))
+	})
+
+	t.Run("reset", func(t *testing.T) {
+		err := transferIndex.reset()
+
+		assert.Nil(t, err)
+		assert.Equal(t, uint64(0), transferIndex.nextBlockToIndex([]string{contract}))
+		assert.Equal(t, big.NewInt(0), balanceAt(holder, 40))
+	})
+}
+
+func TestTransferIndex_keepsLastCheckpoints(t *testing.T) {
+	const contract = "0xA017ac5faC5941f95010b12570B812C974469c2C"
+
+	transferIndex := newTestTransferIndex(t)
+
+	for block := uint64(1); block <= transferIndexMaxCheckpoints+10; block++ {
+		err := transferIndex.add(nil, []string{contract}, block, fmt.Sprintf("0x%x", block))
+		assert.Nil(t, err)
+	}
+
+	checkpoints := transferIndex.checkpoints()
+	assert.Len(t, checkpoints, transferIndexMaxCheckpoints)
+	assert.Equal(t, transferIndexCheckpoint{blockNumber: transferIndexMaxCheckpoints + 10, blockHash: fmt.Sprintf("0x%x", transferIndexMaxCheckpoints+10)}, checkpoints[0])
+	assert.Equal(t, uint64(11), checkpoints[len(checkpoints)-1].blockNumber)
+}
+
+// Opens a transfer index in a temporary directory, closed and removed once the test is done
+func newTestTransferIndex(t *testing.T) *transferIndex {
+	transferIndex, err := NewTransferIndex(t.TempDir())
+	if err != nil {
+		t.Fatalf("opening transfer index. error: %v", err)
+	}
+	t.Cleanup(func() { transferIndex.Close() })
+
+	return transferIndex
+}
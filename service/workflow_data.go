@@ -0,0 +1,67 @@
+package service
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Returns the value at path in the workflow data. Path is a dot separated list of object keys and array indexes,
+// e.g. "customer.wallets.0.address".
+func LookupPath(data map[string]interface{}, path string) (interface{}, bool) {
+	var value interface{} = data
+	for _, segment := range strings.Split(path, ".") {
+		switch container := value.(type) {
+		case map[string]interface{}:
+			var found bool
+			value, found = container[segment]
+			if !found {
+				return nil, false
+			}
+		case []interface{}:
+			index, err := strconv.Atoi(segment)
+			if err != nil || index < 0 || index >= len(container) {
+				return nil, false
+			}
+			value = container[index]
+		default:
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// Adds output to the workflow data, in the sub-object at objectPath (created if missing) or at the top level if
+// objectPath is empty. Each field name is prefixed with prefix. If failOnOverwrite is set, nothing is written when one of
+// the fields already exists.
+func WriteOutput(data map[string]interface{}, output map[string]interface{}, objectPath, prefix string, failOnOverwrite bool) error {
+	target := data
+	if len(objectPath) != 0 {
+		for _, segment := range strings.Split(objectPath, ".") {
+			value, found := target[segment]
+			if !found || value == nil {
+				object := make(map[string]interface{})
+				target[segment] = object
+				target = object
+				continue
+			}
+			object, ok := value.(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("output object %s: field %s isn't an object", objectPath, segment)
+			}
+			target = object
+		}
+	}
+
+	if failOnOverwrite {
+		for field := range output {
+			if _, found := target[prefix+field]; found {
+				return fmt.Errorf("output field %s already exists in the workflow data", prefix+field)
+			}
+		}
+	}
+	for field, value := range output {
+		target[prefix+field] = value
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package service
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLookupPath(t *testing.T) {
+	var data map[string]interface{}
+	err := json.Unmarshal([]byte(`{"ethAddress": "0x1", "customer": {"wallets": [{"address": "0x2"}]}}`), &data)
+	assert.Nil(t, err)
+
+	value, found := LookupPath(data, "ethAddress")
+	assert.True(t, found)
+	assert.Equal(t, "0x1", value)
+
+	value, found = LookupPath(data, "customer.wallets.0.address")
+	assert.True(t, found)
+	assert.Equal(t, "0x2", value)
+
+	_, found = LookupPath(data, "customer.wallets.1.address")
+	assert.False(t, found)
+	_, found = LookupPath(data, "customer.name")
+	assert.False(t, found)
+	_, found = LookupPath(data, "ethAddress.value")
+	assert.False(t, found)
+}
+
+func TestWriteOutput(t *testing.T) {
+	output := map[string]interface{}{"XES": "1.5", "XESBaseUnits": "1500000000000000000"}
+
+	t.Run("ShouldWriteAtTopLevel", func(t *testing.T) {
+		data := map[string]interface{}{"ethAddress": "0x1"}
+		err := WriteOutput(data, output, "", "", false)
+		assert.Nil(t, err)
+		assert.Equal(t, "1.5", data["XES"])
+		assert.Equal(t, "0x1", data["ethAddress"])
+	})
+
+	t.Run("ShouldWriteInSubObjectWithPrefix", func(t *testing.T) {
+		data := map[string]interface{}{"report": map[string]interface{}{"year": "2019"}}
+		err := WriteOutput(data, output, "report.balances", "balance_", false)
+		assert.Nil(t, err)
+
+		value, found := LookupPath(data, "report.balances.balance_XES")
+		assert.True(t, found)
+		assert.Equal(t, "1.5", value)
+		year, _ := LookupPath(data, "report.year")
+		assert.Equal(t, "2019", year)
+	})
+
+	t.Run("ShouldOverwriteByDefault", func(t *testing.T) {
+		data := map[string]interface{}{"XES": "form field"}
+		err := WriteOutput(data, output, "", "", false)
+		assert.Nil(t, err)
+		assert.Equal(t, "1.5", data["XES"])
+	})
+
+	t.Run("ShouldFailInsteadOfOverwriting", func(t *testing.T) {
+		data := map[string]interface{}{"XES": "form field"}
+		err := WriteOutput(data, output, "", "", true)
+		assert.NotNil(t, err)
+		assert.Equal(t, "form field", data["XES"])
+		_, found := data["XESBaseUnits"]
+		assert.False(t, found, "nothing should be written")
+	})
+
+	t.Run("ShouldFailIfOutputObjectIsNotAnObject", func(t *testing.T) {
+		data := map[string]interface{}{"balances": "text"}
+		err := WriteOutput(data, output, "balances", "", false)
+		assert.NotNil(t, err)
+	})
+}